@@ -31,6 +31,35 @@ import (
 type config struct {
 	// When enabled, logcheck will ignore calls to unstructured klog methods (Info, Infof, Error, Errorf, Warningf, etc)
 	allowUnstructured bool
+
+	// Unstructured klog methods which are ignored even when allowUnstructured is disabled.
+	allowedFunctions stringList
+}
+
+// stringList is a flag.Value for a comma-separated list of strings.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+func (l stringList) contains(item string) bool {
+	for _, i := range l {
+		if i == item {
+			return true
+		}
+	}
+	return false
 }
 
 func main() {
@@ -42,6 +71,8 @@ func analyser() *analysis.Analyzer {
 	logcheckFlags := flag.NewFlagSet("", flag.ExitOnError)
 	logcheckFlags.BoolVar(&c.allowUnstructured, "allow-unstructured", c.allowUnstructured, `when enabled, logcheck will ignore calls to unstructured
 klog methods (Info, Infof, Error, Errorf, Warningf, etc)`)
+	logcheckFlags.Var(&c.allowedFunctions, "allow", `comma-separated list of unstructured klog methods
+(for example Infof,Errorf) which are ignored even when unstructured logging is not allowed`)
 
 	return &analysis.Analyzer{
 		Name: "logcheck",
@@ -57,12 +88,15 @@ func run(pass *analysis.Pass, c *config) (interface{}, error) {
 
 	for _, file := range pass.Files {
 
+		// Diagnostics for lines with a //nolint:logcheck comment are dropped.
+		filePass := filterNolint(pass, file)
+
 		ast.Inspect(file, func(n ast.Node) bool {
 
 			// We are intrested in function calls, as we want to detect klog.* calls
 			// passing all function calls to checkForFunctionExpr
 			if fexpr, ok := n.(*ast.CallExpr); ok {
-				checkForFunctionExpr(fexpr, pass, c)
+				checkForFunctionExpr(fexpr, filePass, c)
 			}
 
 			return true
@@ -71,6 +105,48 @@ func run(pass *analysis.Pass, c *config) (interface{}, error) {
 	return nil, nil
 }
 
+// filterNolint returns a copy of pass which does not report diagnostics for
+// lines of file that carry a "//nolint:logcheck" comment. The comment may also
+// list other linters, as in "//nolint:errcheck,logcheck".
+func filterNolint(pass *analysis.Pass, file *ast.File) *analysis.Pass {
+	suppressed := map[int]bool{}
+	for _, group := range file.Comments {
+		for _, comment := range group.List {
+			if isNolintLogcheck(comment.Text) {
+				suppressed[pass.Fset.Position(comment.Slash).Line] = true
+			}
+		}
+	}
+	if len(suppressed) == 0 {
+		return pass
+	}
+	filtered := *pass
+	filtered.Report = func(d analysis.Diagnostic) {
+		if !suppressed[pass.Fset.Position(d.Pos).Line] {
+			pass.Report(d)
+		}
+	}
+	return &filtered
+}
+
+func isNolintLogcheck(text string) bool {
+	text = strings.TrimPrefix(text, "//")
+	if !strings.HasPrefix(text, "nolint:") {
+		return false
+	}
+	// Anything after the list of linters is an explanation.
+	linters := strings.Fields(strings.TrimPrefix(text, "nolint:"))
+	if len(linters) == 0 {
+		return false
+	}
+	for _, linter := range strings.Split(linters[0], ",") {
+		if linter == "logcheck" {
+			return true
+		}
+	}
+	return false
+}
+
 // checkForFunctionExpr checks for unstructured logging function, prints error if found any.
 func checkForFunctionExpr(fexpr *ast.CallExpr, pass *analysis.Pass, c *config) {
 
@@ -88,9 +164,11 @@ func checkForFunctionExpr(fexpr *ast.CallExpr, pass *analysis.Pass, c *config) {
 		// for nested function cases klog.V(1).Infof scenerios
 		// if selExpr.X contains one more caller expression which is selector expression
 		// we are extracting klog and discarding V(1)
+		verbose := false
 		if n, ok := selExpr.X.(*ast.CallExpr); ok {
 			if _, ok = n.Fun.(*ast.SelectorExpr); ok {
 				selExpr = n.Fun.(*ast.SelectorExpr)
+				verbose = true
 			}
 		}
 
@@ -98,6 +176,10 @@ func checkForFunctionExpr(fexpr *ast.CallExpr, pass *analysis.Pass, c *config) {
 		pName, ok := selExpr.X.(*ast.Ident)
 
 		if ok && pName.Name == "klog" {
+			if !c.allowUnstructured && !c.allowedFunctions.contains(fName) {
+				checkForSprintf(fName, verbose, args, pass)
+			}
+
 			// Matching if any unstructured logging function is used.
 			if !isUnstructured((fName)) {
 				// if format specifier is used, check for arg length will most probably fail
@@ -110,7 +192,7 @@ func checkForFunctionExpr(fexpr *ast.CallExpr, pass *analysis.Pass, c *config) {
 				} else if fName == "ErrorS" {
 					isKeysValid(args[2:], fun, pass, fName)
 				}
-			} else if !c.allowUnstructured {
+			} else if !c.allowUnstructured && !c.allowedFunctions.contains(fName) {
 				msg := fmt.Sprintf("unstructured logging function %q should not be used", fName)
 				pass.Report(analysis.Diagnostic{
 					Pos:     fun.Pos(),
@@ -121,6 +203,57 @@ func checkForFunctionExpr(fexpr *ast.CallExpr, pass *analysis.Pass, c *config) {
 	}
 }
 
+// checkForSprintf reports calls of logging functions which build the
+// message or format with fmt.Sprintf or fmt.Sprintln. That is redundant for
+// the formatting functions and defeats structured logging for the others.
+// Other arguments and other klog functions are not checked.
+func checkForSprintf(fName string, verbose bool, args []ast.Expr, pass *analysis.Pass) {
+	if !isUnstructured(fName) && !isStructured(fName) {
+		return
+	}
+	// Find the message parameter, which comes after the depth and the error.
+	msgIndex := 0
+	switch {
+	case fName == "ErrorSDepth":
+		msgIndex = 2
+	case fName == "ErrorS", strings.HasSuffix(fName, "Depth"):
+		msgIndex = 1
+	case fName == "Error" && verbose:
+		// klog.V(1).Error is the deprecated structured variant.
+		msgIndex = 1
+	}
+	if len(args) <= msgIndex {
+		return
+	}
+	call, ok := args[msgIndex].(*ast.CallExpr)
+	if !ok {
+		return
+	}
+	selExpr, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return
+	}
+	if pName, ok := selExpr.X.(*ast.Ident); !ok || pName.Name != "fmt" {
+		return
+	}
+	if fmtName := selExpr.Sel.Name; fmtName == "Sprintf" || fmtName == "Sprintln" {
+		pass.Report(analysis.Diagnostic{
+			Pos:     call.Pos(),
+			Message: fmt.Sprintf("logging function %q should not use fmt.%s to build the message, format directly or use key/value pairs instead", fName, fmtName),
+		})
+	}
+}
+
+// isStructured reports whether fName is one of the structured logging
+// functions.
+func isStructured(fName string) bool {
+	switch fName {
+	case "InfoS", "ErrorS", "InfoSDepth", "ErrorSDepth":
+		return true
+	}
+	return false
+}
+
 func isUnstructured(fName string) bool {
 
 	// List of klog functions we do not want to use after migration to structured logging.
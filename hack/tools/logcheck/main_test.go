@@ -26,6 +26,7 @@ func TestAnalyzer(t *testing.T) {
 	tests := []struct {
 		name              string
 		allowUnstructured string
+		allow             string
 		testPackage       string
 	}{
 		{
@@ -38,11 +39,33 @@ func TestAnalyzer(t *testing.T) {
 			allowUnstructured: "false",
 			testPackage:       "doNotAllowUnstructuredLogs",
 		},
+		{
+			name:              "Allow some unstructured logs",
+			allowUnstructured: "false",
+			allow:             "Infof, Errorf",
+			testPackage:       "allowlistedUnstructuredLogs",
+		},
+		{
+			name:              "Suppress with nolint comments",
+			allowUnstructured: "false",
+			testPackage:       "nolint",
+		},
+		{
+			name:              "Detect messages built with fmt.Sprintf",
+			allowUnstructured: "false",
+			testPackage:       "sprintfLogs",
+		},
+		{
+			name:              "Allow messages built with fmt.Sprintf",
+			allowUnstructured: "true",
+			testPackage:       "allowSprintfLogs",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			analyzer := analyser()
 			analyzer.Flags.Set("allow-unstructured", tt.allowUnstructured)
+			analyzer.Flags.Set("allow", tt.allow)
 			analysistest.Run(t, analysistest.TestData(), analyzer, tt.testPackage)
 		})
 	}
@@ -0,0 +1,44 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This fake package is created as golang.org/x/tools/go/analysis/analysistest
+// expects it to be here for loading. This package is used to test that log
+// messages built with fmt.Sprintf or fmt.Sprintln are not reported when
+// unstructured logging is allowed.
+// This is a test file for unstructured logging static check tool unit tests.
+
+package allowSprintfLogs
+
+import (
+	"fmt"
+
+	klog "k8s.io/klog/v2"
+)
+
+func allowSprintfLogs() {
+	name := "kube-dns"
+
+	// Error is not expected as this package allows unstructured logging
+	klog.Info(fmt.Sprintf("pod %s", name))
+	klog.Infoln(fmt.Sprintln("pod", name))
+	klog.V(1).Info(fmt.Sprintf("pod %s", name))
+	klog.InfoS(fmt.Sprintf("pod %s", name))
+	klog.V(1).InfoS(fmt.Sprintf("pod %s", name))
+	klog.ErrorS(nil, fmt.Sprintf("pod %s", name))
+	klog.V(1).Error(nil, fmt.Sprintf("pod %s", name))
+	klog.InfoDepth(1, fmt.Sprintf("pod %s", name))
+	klog.InfoSDepth(1, fmt.Sprintf("pod %s", name), "a", "b")
+}
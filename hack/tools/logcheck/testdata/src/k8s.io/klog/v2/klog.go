@@ -180,3 +180,14 @@ func Fatalln(args ...interface{}) {
 // Arguments are handled in the manner of fmt.Printf; a newline is appended if missing.
 func Fatalf(format string, args ...interface{}) {
 }
+
+// ObjectRef references a Kubernetes object.
+type ObjectRef struct {
+	Name      string
+	Namespace string
+}
+
+// KRef returns ObjectRef from name and namespace.
+func KRef(namespace, name string) ObjectRef {
+	return ObjectRef{Name: name, Namespace: namespace}
+}
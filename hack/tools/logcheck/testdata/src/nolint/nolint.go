@@ -0,0 +1,41 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This fake package is created as golang.org/x/tools/go/analysis/analysistest
+// expects it to be here for loading. This package is used to test that
+// //nolint:logcheck comments suppress errors on the same line.
+// This is a test file for unstructured logging static check tool unit tests.
+
+package nolint
+
+import (
+	klog "k8s.io/klog/v2"
+)
+
+func nolint() {
+	// Error is not expected on lines with a suppression comment
+	klog.Infof("test log")                                   //nolint:logcheck
+	klog.Errorf("test log")                                  //nolint:errcheck,logcheck // Explanation.
+	klog.InfoS("Starting container in a pod", "containerID") //nolint:logcheck
+
+	// Error is expected without a suppression comment or for other linters
+	klog.Infof("test log")                                   // want `unstructured logging function "Infof" should not be used`
+	klog.Errorf("test log")                                  //nolint:errcheck // want `unstructured logging function "Errorf" should not be used`
+	klog.InfoS("Starting container in a pod", "containerID") // want `Additional arguments to InfoS should always be Key Value pairs. Please check if there is any key or value missing.`
+	// A suppression comment only applies to its own line
+	//nolint:logcheck
+	klog.Warning("test log") // want `unstructured logging function "Warning" should not be used`
+}
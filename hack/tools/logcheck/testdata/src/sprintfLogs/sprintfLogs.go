@@ -0,0 +1,51 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This fake package is created as golang.org/x/tools/go/analysis/analysistest
+// expects it to be here for loading. This package is used to test detection
+// of log messages which are built with fmt.Sprintf or fmt.Sprintln when
+// unstructured logging is not allowed.
+// This is a test file for unstructured logging static check tool unit tests.
+
+package sprintfLogs
+
+import (
+	"fmt"
+
+	klog "k8s.io/klog/v2"
+)
+
+func sprintfLogs() {
+	name := "kube-dns"
+
+	// Error is expected if the message is built with fmt.Sprintf or fmt.Sprintln
+	klog.Info(fmt.Sprintf("pod %s", name))                    // want `logging function "Info" should not use fmt.Sprintf to build the message, format directly or use key/value pairs instead` `unstructured logging function "Info" should not be used`
+	klog.Infoln(fmt.Sprintln("pod", name))                    // want `logging function "Infoln" should not use fmt.Sprintln to build the message, format directly or use key/value pairs instead` `unstructured logging function "Infoln" should not be used`
+	klog.V(1).Info(fmt.Sprintf("pod %s", name))               // want `logging function "Info" should not use fmt.Sprintf to build the message, format directly or use key/value pairs instead` `unstructured logging function "Info" should not be used`
+	klog.InfoS(fmt.Sprintf("pod %s", name))                   // want `logging function "InfoS" should not use fmt.Sprintf to build the message, format directly or use key/value pairs instead`
+	klog.V(1).InfoS(fmt.Sprintf("pod %s", name))              // want `logging function "InfoS" should not use fmt.Sprintf to build the message, format directly or use key/value pairs instead`
+	klog.ErrorS(nil, fmt.Sprintf("pod %s", name))             // want `logging function "ErrorS" should not use fmt.Sprintf to build the message, format directly or use key/value pairs instead`
+	klog.V(1).Error(nil, fmt.Sprintf("pod %s", name))         // want `logging function "Error" should not use fmt.Sprintf to build the message, format directly or use key/value pairs instead` `unstructured logging function "Error" should not be used`
+	klog.InfoDepth(1, fmt.Sprintf("pod %s", name))            // want `logging function "InfoDepth" should not use fmt.Sprintf to build the message, format directly or use key/value pairs instead` `unstructured logging function "InfoDepth" should not be used`
+	klog.InfoSDepth(1, fmt.Sprintf("pod %s", name), "a", "b") // want `logging function "InfoSDepth" should not use fmt.Sprintf to build the message, format directly or use key/value pairs instead`
+
+	// Other uses of fmt are not reported
+	klog.Infof("pod %s", name)                 // want `unstructured logging function "Infof" should not be used`
+	klog.Info("pod ", fmt.Sprintf("%s", name)) // want `unstructured logging function "Info" should not be used`
+	klog.InfoS("Pod is ready", "pod", fmt.Sprintf("%s", name))
+	klog.Info(fmt.Sprint("pod ", name)) // want `unstructured logging function "Info" should not be used`
+	klog.InfoS("Pod is ready", "pod", klog.KRef(fmt.Sprintf("ns-%d", 1), name))
+}
@@ -0,0 +1,80 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package http contains helpers for logging HTTP requests with klog. It is
+// separate from klog so that klog itself does not depend on net/http.
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// HTTPRequest returns a value which describes the request as "GET /path"
+// in the text output of klog and as an object with method, path and host
+// for structured logging backends. Nothing is computed before the entry
+// is written, so passing it to a disabled V(n).InfoS is cheap.
+//
+// Basic example:
+// >> klog.V(4).InfoS("Handling request", "request", http.HTTPRequest(r))
+// output:
+// >> I1025 00:15:15.525108       1 server.go:74] "Handling request" request="GET /healthz"
+func HTTPRequest(r *http.Request) fmt.Stringer {
+	return request{r}
+}
+
+// request is the value returned by HTTPRequest.
+type request struct {
+	r *http.Request
+}
+
+// String returns the method and the path of the request.
+func (r request) String() string {
+	if r.r == nil {
+		return "<nil>"
+	}
+	return r.r.Method + " " + r.path()
+}
+
+// MarshalLog returns the fields of the request for structured logging.
+func (r request) MarshalLog() interface{} {
+	if r.r == nil {
+		return nil
+	}
+	return struct {
+		Method string `json:"method"`
+		Path   string `json:"path"`
+		Host   string `json:"host,omitempty"`
+	}{
+		Method: r.r.Method,
+		Path:   r.path(),
+		Host:   r.r.Host,
+	}
+}
+
+// MarshalJSON encodes the fields returned by MarshalLog. Backends like klogr
+// which serialize values as JSON use it.
+func (r request) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.MarshalLog())
+}
+
+// path returns the path of the request URL, or the raw request URI if
+// the URL is missing.
+func (r request) path() string {
+	if r.r.URL == nil {
+		return r.r.RequestURI
+	}
+	return r.r.URL.Path
+}
@@ -0,0 +1,53 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package http
+
+import (
+	"bytes"
+	"flag"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"k8s.io/klog/v2"
+	"k8s.io/klog/v2/klogr"
+)
+
+func TestHTTPRequest(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.PanicOnError)
+	klog.InitFlags(fs)
+	fs.Set("logtostderr", "false")
+	fs.Set("skip_headers", "true")
+	var buf bytes.Buffer
+	klog.SetOutputBySeverity("INFO", &buf)
+
+	r := httptest.NewRequest("GET", "http://example.com/healthz?verbose=1", nil)
+	klog.InfoS("Handling request", "request", HTTPRequest(r))
+	klog.Flush()
+	if want := `"Handling request" request="GET /healthz"` + "\n"; buf.String() != want {
+		t.Errorf("expected %q, got %q", want, buf.String())
+	}
+
+	buf.Reset()
+	klogr.NewWithOptions(klogr.WithFormat(klogr.FormatSerialize)).Info("Handling request", "request", HTTPRequest(r))
+	klog.Flush()
+	if want := `"request"={"method":"GET","path":"/healthz","host":"example.com"}`; !strings.Contains(buf.String(), want) {
+		t.Errorf("expected %s in the klogr output, got %q", want, buf.String())
+	}
+
+	if s := HTTPRequest(nil).String(); !strings.Contains(s, "nil") {
+		t.Errorf("unexpected text for a nil request: %q", s)
+	}
+}
@@ -73,6 +73,8 @@ package klog
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -151,7 +153,7 @@ func (s *severity) Set(value string) error {
 		}
 		threshold = severity(v)
 	}
-	logging.stderrThreshold.set(threshold)
+	s.set(threshold)
 	return nil
 }
 
@@ -317,6 +319,25 @@ func (m *moduleSpec) Set(value string) error {
 	return nil
 }
 
+// VModuleRule is one pattern=N entry of the -vmodule flag.
+type VModuleRule struct {
+	Pattern string
+	Level   Level
+}
+
+// VModuleRules returns the rules currently set with -vmodule or
+// SetVModule, in the order in which they are applied. Rules with level 0
+// have no effect and are not included.
+func VModuleRules() []VModuleRule {
+	logging.mu.Lock()
+	defer logging.mu.Unlock()
+	rules := make([]VModuleRule, 0, len(logging.vmodule.filter))
+	for _, f := range logging.vmodule.filter {
+		rules = append(rules, VModuleRule{Pattern: f.pattern, Level: f.level})
+	}
+	return rules
+}
+
 // isLiteral reports whether the pattern is a literal string, that is, has no metacharacters
 // that require filepath.Match to be called to match the pattern.
 func isLiteral(pattern string) bool {
@@ -397,6 +418,47 @@ func (t *traceLocation) Set(value string) error {
 	return nil
 }
 
+// fatalStacksMode is the setting of the -log_fatal_stacks flag.
+type fatalStacksMode int32
+
+const (
+	fatalStacksAll fatalStacksMode = iota
+	fatalStacksCurrent
+	fatalStacksNone
+)
+
+var fatalStacksName = []string{
+	fatalStacksAll:     "all",
+	fatalStacksCurrent: "current",
+	fatalStacksNone:    "none",
+}
+
+func (m *fatalStacksMode) String() string {
+	logging.mu.Lock()
+	defer logging.mu.Unlock()
+	return fatalStacksName[*m]
+}
+
+// Get is part of the flag.Getter interface.
+func (m *fatalStacksMode) Get() interface{} {
+	logging.mu.Lock()
+	defer logging.mu.Unlock()
+	return fatalStacksName[*m]
+}
+
+// Set is part of the flag.Value interface.
+func (m *fatalStacksMode) Set(value string) error {
+	for i, name := range fatalStacksName {
+		if name == value {
+			logging.mu.Lock()
+			defer logging.mu.Unlock()
+			*m = fatalStacksMode(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid value %q, must be one of all, current or none", value)
+}
+
 // flushSyncWriter is the interface satisfied by logging destinations.
 type flushSyncWriter interface {
 	Flush() error
@@ -404,7 +466,7 @@ type flushSyncWriter interface {
 	io.Writer
 }
 
-// init sets up the defaults and runs flushDaemon.
+// init sets up the defaults and starts the flush daemon.
 func init() {
 	logging.stderrThreshold = errorLog // Default stderrThreshold is ERROR.
 	logging.setVState(0, nil, false)
@@ -417,7 +479,9 @@ func init() {
 	logging.addDirHeader = false
 	logging.skipLogHeaders = false
 	logging.oneOutput = false
-	go logging.flushDaemon()
+	logging.debugVerbosity.set(defaultDebugVerbosity)
+	logging.flushD = newFlushDaemon(logging.lockAndFlushDaemon)
+	logging.flushD.run(flushInterval)
 }
 
 // InitFlags is for explicitly initializing the flags.
@@ -431,23 +495,224 @@ func InitFlags(flagset *flag.FlagSet) {
 	flagset.Uint64Var(&logging.logFileMaxSizeMB, "log_file_max_size", logging.logFileMaxSizeMB,
 		"Defines the maximum size a log file can grow to. Unit is megabytes. "+
 			"If the value is 0, the maximum file size is unlimited.")
+	flagset.DurationVar(&logging.logFileMaxAge, "log_file_max_age", logging.logFileMaxAge,
+		"Defines the maximum age of a log file, for example 24h, after which it gets rotated like a file "+
+			"that reached log_file_max_size. If the value is 0, the age is not limited.")
+	flagset.IntVar(&logging.logFileMaxCount, "log_file_max_count", logging.logFileMaxCount,
+		"Defines the maximum number of log files per severity that are kept in the log directory, "+
+			"including the current one. Older files are deleted when a new file is created. "+
+			"If the value is 0, the number of files is unlimited. Ignored when log_file is set.")
+	flagset.BoolVar(&logging.logFileCompress, "log_file_compress", logging.logFileCompress,
+		"If true, log files in the log directory are compressed with gzip in the background after they were rotated. "+
+			"Ignored when log_file is set.")
+	flagset.BoolVar(&logging.hostPID, "log_host_pid", logging.hostPID,
+		"If true, structured log entries include the host name and process ID under the host and pid keys")
+	flagset.BoolVar(&logging.uptime, "log_uptime", logging.uptime,
+		"If true, structured log entries include the time since process start under the uptime key")
+	flagset.BoolVar(&logging.delta, "log_delta", logging.delta,
+		"If true, structured log entries include the time since the previous log entry of the process under the delta key")
+	flagset.BoolVar(&logging.errorCauses, "log_error_causes", logging.errorCauses,
+		"If true, structured error log entries include the messages of the wrapped errors under the errCauses key")
 	flagset.BoolVar(&logging.toStderr, "logtostderr", logging.toStderr, "log to standard error instead of files")
 	flagset.BoolVar(&logging.alsoToStderr, "alsologtostderr", logging.alsoToStderr, "log to standard error as well as files")
 	flagset.Var(&logging.verbosity, "v", "number for the log level verbosity")
 	flagset.BoolVar(&logging.addDirHeader, "add_dir_header", logging.addDirHeader, "If true, adds the file directory to the header of the log messages")
+	flagset.BoolVar(&logging.callerFunc, "log_caller_func", logging.callerFunc, "If true, adds the name of the calling function after the line number in the header of the log messages")
+	flagset.BoolVar(&logging.logfmt, "log_logfmt", logging.logfmt, "If true, writes the header fields and the message as logfmt key/value pairs (level, ts, caller and msg) instead of the klog header")
 	flagset.BoolVar(&logging.skipHeaders, "skip_headers", logging.skipHeaders, "If true, avoid header prefixes in the log messages")
 	flagset.BoolVar(&logging.oneOutput, "one_output", logging.oneOutput, "If true, only write logs to their native severity level (vs also writing to each lower severity level)")
 	flagset.BoolVar(&logging.skipLogHeaders, "skip_log_headers", logging.skipLogHeaders, "If true, avoid headers when opening log files")
+	flagset.BoolVar(&logging.dedup, "log_dedup", logging.dedup, "If true, an entry identical to the previous one of the same severity is replaced by a \"(last message repeated N times)\" summary, written before the next different entry or on flush")
+	flagset.BoolVar(&logging.sequence, "log_sequence", logging.sequence, "If true, prefix each log line with a sequence number which is shared by all severities, for detecting lost lines")
+	flagset.BoolVar(&logging.monotonic, "log_monotonic", logging.monotonic, "If true, prefix each log line with the number of nanoseconds since process start, strictly increasing across all lines")
 	flagset.Var(&logging.stderrThreshold, "stderrthreshold", "logs at or above this threshold go to stderr")
+	flagset.Var(&logging.fileThreshold, "log_file_threshold", "logs at or above this threshold go to the log files (default INFO)")
 	flagset.Var(&logging.vmodule, "vmodule", "comma-separated list of pattern=N settings for file-filtered logging")
 	flagset.Var(&logging.traceLocation, "log_backtrace_at", "when logging hits line file:N, emit a stack trace")
+	flagset.Var(&logging.fatalStacks, "log_fatal_stacks", "which goroutine stacks to dump when logging a fatal message: all, current or none")
+	flagset.Var(logging.flushD, "log_flush_frequency", "Maximum time between periodic log flushes, for example 5s. Changing it restarts the periodic flushing; explicit calls to Flush are not affected")
+}
+
+// InitFlagsWithPrefix is like InitFlags, except that the name of each flag
+// is prefixed with the given string, for example "klog." to get -klog.v
+// instead of -v. This avoids conflicts with flags of other packages. The
+// flags share their state with those registered by InitFlags.
+func InitFlagsWithPrefix(prefix string, flagset *flag.FlagSet) {
+	if flagset == nil {
+		flagset = flag.CommandLine
+	}
+	var fs flag.FlagSet
+	InitFlags(&fs)
+	fs.VisitAll(func(f *flag.Flag) {
+		flagset.Var(f.Value, prefix+f.Name, f.Usage)
+	})
+}
+
+// The following functions change the same settings as the corresponding
+// flags registered by InitFlags, without going through their string
+// representation. Like the flags, the settings are read without locking
+// while logging, so they should be changed before logging starts.
+
+// SetVerbosity sets the verbosity level, like -v.
+func SetVerbosity(v Level) {
+	logging.mu.Lock()
+	defer logging.mu.Unlock()
+	logging.setVState(v, logging.vmodule.filter, false)
+}
+
+// Verbosity returns the verbosity level set with -v or SetVerbosity.
+// -vmodule and call site overrides are not taken into account.
+func Verbosity() Level {
+	return logging.verbosity.get()
+}
+
+// SetVModule replaces the per-file verbosity settings, like -vmodule. The
+// spec is a comma-separated list of pattern=N settings.
+func SetVModule(spec string) error {
+	return logging.vmodule.Set(spec)
+}
+
+// SetStderrThreshold sets the severity at or above which logs also go to
+// stderr, like -stderrthreshold. The severity is given by name ("INFO",
+// "WARNING", "ERROR" or "FATAL") or number.
+func SetStderrThreshold(severity string) error {
+	return logging.stderrThreshold.Set(severity)
+}
+
+// SetLogFileThreshold sets the severity at or above which logs go to the
+// log files, like -log_file_threshold. Entries below it are only written to
+// stderr, if at all.
+func SetLogFileThreshold(severity string) error {
+	return logging.fileThreshold.Set(severity)
+}
+
+// SetLogToStderr enables or disables logging to stderr instead of files,
+// like -logtostderr.
+func SetLogToStderr(enabled bool) {
+	logging.mu.Lock()
+	defer logging.mu.Unlock()
+	logging.toStderr = enabled
+}
+
+// SetAlsoLogToStderr enables or disables logging to stderr in addition to
+// files, like -alsologtostderr.
+func SetAlsoLogToStderr(enabled bool) {
+	logging.mu.Lock()
+	defer logging.mu.Unlock()
+	logging.alsoToStderr = enabled
+}
+
+// SetLogDir sets the directory for log files, like -log_dir. It has no
+// effect once the first log file was created.
+func SetLogDir(dir string) {
+	logging.mu.Lock()
+	defer logging.mu.Unlock()
+	logging.logDir = dir
+}
+
+// SetLogFile sets a single file to write all logs to, like -log_file. It
+// has no effect once the first log file was created.
+func SetLogFile(path string) {
+	logging.mu.Lock()
+	defer logging.mu.Unlock()
+	logging.logFile = path
+}
+
+// SetLogFilePattern sets the full path of the log files as a pattern with
+// placeholders which get replaced each time a file is created, also when
+// rotating: {severity} by the severity of the file (INFO, WARNING, ...),
+// {pid} by the process ID, {host} by the short host name and {date} by the
+// creation time as yyyymmdd-hhmmss.
+//
+// The pattern should contain {severity}, because each severity gets its own
+// file, and {date}, because otherwise rotation truncates the current file.
+// Unlike the default file names, no symlinks are created and -log_file_max_count
+// does not apply. The pattern overrides -log_dir and is ignored when -log_file
+// is set. It has no effect on files which are already open.
+//
+// Basic example:
+// >> klog.SetLogFilePattern("/var/log/app-{severity}-{pid}-{date}.log")
+func SetLogFilePattern(pattern string) {
+	logging.mu.Lock()
+	defer logging.mu.Unlock()
+	logging.logFilePattern = pattern
+}
+
+// SetLogFileMaxSize sets the maximum size of a log file in megabytes, like
+// -log_file_max_size. Zero means unlimited. It applies to log files
+// created afterwards.
+func SetLogFileMaxSize(mb uint64) {
+	logging.mu.Lock()
+	defer logging.mu.Unlock()
+	logging.logFileMaxSizeMB = mb
+}
+
+// SetSkipHeaders enables or disables the header prefix of log entries,
+// like -skip_headers.
+func SetSkipHeaders(skip bool) {
+	logging.mu.Lock()
+	defer logging.mu.Unlock()
+	logging.skipHeaders = skip
+}
+
+// SetAddDirHeader enables or disables the file directory in the header of
+// log entries, like -add_dir_header.
+func SetAddDirHeader(enabled bool) {
+	logging.mu.Lock()
+	defer logging.mu.Unlock()
+	logging.addDirHeader = enabled
 }
 
-// Flush flushes all pending log I/O.
+// SetCallerPrefixTrim makes the header show the full path of the source file
+// without the given prefix, instead of only the base name, for files whose
+// path starts with prefix. For example, with prefix "/home/build/src/" the
+// file "/home/build/src/k8s.io/foo.go" is shown as "k8s.io/foo.go". Other
+// files are shown as before. An empty prefix disables trimming.
+func SetCallerPrefixTrim(prefix string) {
+	logging.callerPrefixTrim.Store(prefix)
+}
+
+// SetOneOutput enables or disables writing log entries only to the file of
+// their own severity, like -one_output.
+func SetOneOutput(enabled bool) {
+	logging.mu.Lock()
+	defer logging.mu.Unlock()
+	logging.oneOutput = enabled
+}
+
+// Flush flushes all pending log I/O. Independently of explicit calls,
+// buffered data is also flushed periodically at the interval configured
+// with -log_flush_frequency or StartFlushDaemon.
 func Flush() {
 	logging.lockAndFlushAll()
 }
 
+// FlushE is like Flush but returns the errors encountered while flushing
+// and syncing the output of each severity, for example because the disk is
+// full. All outputs are flushed even if some of them fail.
+func FlushE() error {
+	logging.mu.Lock()
+	err := logging.flushAll()
+	logging.mu.Unlock()
+	logging.runFlushHook()
+	return err
+}
+
+// SetFlushHook installs a function which is called after each flush of all
+// outputs, by Flush, FlushE and the flush daemon. Tests can use it to wait
+// for a flush instead of sleeping. The function is called without holding
+// any klog lock, so it may log. Nil removes the hook.
+func SetFlushHook(hook func()) {
+	logging.flushHook.Store(hook)
+}
+
+// runFlushHook calls the function installed with SetFlushHook, if any.
+func (l *loggingT) runFlushHook() {
+	if hook, _ := l.flushHook.Load().(func()); hook != nil {
+		hook()
+	}
+}
+
 // loggingT collects all the global state of the logging setup.
 type loggingT struct {
 	// Boolean flags. Not handled atomically because the flag.Value interface
@@ -458,6 +723,7 @@ type loggingT struct {
 
 	// Level flag. Handled atomically.
 	stderrThreshold severity // The -stderrthreshold flag.
+	fileThreshold   severity // The -log_file_threshold flag.
 
 	// freeList is a list of byte buffers, maintained under freeListMu.
 	freeList *buffer
@@ -471,6 +737,8 @@ type loggingT struct {
 	mu sync.Mutex
 	// file holds writer for each of the log types.
 	file [numSeverity]flushSyncWriter
+	// flushD holds a flushDaemon that frequently flushes log file buffers.
+	flushD *flushDaemon
 	// pcs is used in V to avoid an allocation when computing the caller's PC.
 	pcs [1]uintptr
 	// vmap is a cache of the V Level for each V() call site, identified by PC.
@@ -482,6 +750,19 @@ type loggingT struct {
 	filterLength int32
 	// traceLocation is the state of the -log_backtrace_at flag.
 	traceLocation traceLocation
+	// callSites holds the verbosity overrides set with SetCallSiteVerbosity.
+	callSites map[callSite]Level
+	// csmap is a cache of the call site override for each V() call site,
+	// identified by PC. It is wiped whenever callSites changes.
+	csmap map[uintptr]callSiteLevel
+	// callSitesLength stores the number of call site overrides. If greater
+	// than zero, V consults them. It may be read safely using
+	// sync.LoadInt32, but is only modified under mu.
+	callSitesLength int32
+
+	// discard is 1 if logr is logr.Discard(), checked by LoggingEnabled.
+	discard int32
+
 	// These flags are modified only under lock, although verbosity may be fetched
 	// safely using atomic.LoadInt32.
 	vmodule   moduleSpec // The state of the -vmodule flag.
@@ -495,10 +776,57 @@ type loggingT struct {
 	// with the log_dir option.
 	logFile string
 
+	// If non-empty, the pattern for the log file names set with
+	// SetLogFilePattern. Overrides logDir, ignored when logFile is set.
+	logFilePattern string
+
 	// When logFile is specified, this limiter makes sure the logFile won't exceeds a certain size. When exceeds, the
 	// logFile will be cleaned up. If this value is 0, no size limitation will be applied to logFile.
 	logFileMaxSizeMB uint64
 
+	// If non-zero, log files are rotated once they are older than this,
+	// in addition to the rotation based on size.
+	logFileMaxAge time.Duration
+
+	// If non-zero, only this many log files per severity are kept in the log
+	// directory. Older ones are deleted whenever a new file gets created.
+	logFileMaxCount int
+
+	// If true, rotated log files are compressed with gzip in the background.
+	logFileCompress bool
+
+	// If true, structured error entries also list the wrapped errors.
+	errorCauses bool
+
+	// If true, structured entries get host and pid key/value pairs.
+	hostPID bool
+
+	// If true, structured entries get the time since process start.
+	uptime bool
+
+	// If true, structured entries get the time since the previous entry.
+	delta bool
+	// lastOutput is the time of the previous entry relative to
+	// processStart. It is protected by mu.
+	lastOutput time.Duration
+
+	// Which goroutine stacks are dumped on Fatal.
+	fatalStacks fatalStacksMode
+
+	// What happens after a fatal log entry was written.
+	fatalBehavior FatalBehavior
+
+	// The verbosity at which Debug and DebugS log. Handled atomically.
+	debugVerbosity Level
+
+	// If non-zero, odd key/value lists trigger a warning. Handled atomically.
+	strictKVs int32
+
+	// Callbacks registered with OnRotate and the rotations which still need
+	// to be reported to them once mu is released.
+	rotateHooks      []func(oldPath, newPath string)
+	pendingRotations []rotation
+
 	// If true, do not add the prefix headers, useful when used with SetOutput
 	skipHeaders bool
 
@@ -508,21 +836,85 @@ type loggingT struct {
 	// If true, add the file directory to the header
 	addDirHeader bool
 
+	// callerPrefixTrim holds the string set with SetCallerPrefixTrim. If
+	// set, the header contains the path of source files with this prefix,
+	// without the prefix, instead of their base name.
+	callerPrefixTrim atomic.Value
+
 	// If set, all output will be redirected unconditionally to the provided logr.Logger
 	logr logr.Logger
 
 	// If true, messages will not be propagated to lower severity log levels
 	oneOutput bool
 
+	// If true, add the name of the calling function to the header
+	callerFunc bool
+
+	// If true, write the header and message as logfmt key/value pairs
+	logfmt bool
+
+	// If true, prefix each line with a strictly increasing monotonic timestamp
+	monotonic bool
+	// lastMonotonic is the last monotonic timestamp that was written.
+	lastMonotonic int64
+	// If true, prefix each line with a sequence number.
+	sequence bool
+	// lastSequence is the last sequence number that was written.
+	lastSequence uint64
+
+	// If true, suppress entries which repeat the previous entry of the same
+	// severity and write a summary instead.
+	dedup bool
+	// repeats holds the previous entry of each severity for dedup.
+	repeats [numSeverity]repeatState
+
+	// If true, INFO and WARNING entries are held back until the first
+	// error, see SetBufferUntilError.
+	bufferUntilError bool
+	// held contains the entries held back while bufferUntilError is true.
+	// Once it has maxHeldEntries entries, it is used as ring buffer and
+	// heldStart is the index of the oldest entry.
+	held      []heldEntry
+	heldStart int
+
 	// If set, all output will be filtered through the filter.
 	filter LogFilter
+
+	// severityTokens holds a *[numSeverity]string with the tokens that
+	// replace severityChar in the header. Nil means the default tokens.
+	severityTokens atomic.Value
+
+	// valueRedactor holds the func(key string, value interface{}) interface{}
+	// installed with SetValueRedactor. Nil means no redaction.
+	valueRedactor atomic.Value
+
+	// nameRenderer holds the func(segments []string) string installed with
+	// SetNameRenderer. Nil means the default "/" separated names.
+	nameRenderer atomic.Value
+
+	// protoFormatter holds the func(m interface{}) (string, bool) installed
+	// with SetProtoFormatter. Nil means protobuf messages are formatted like
+	// other values.
+	protoFormatter atomic.Value
+
+	// flushHook holds the func() installed with SetFlushHook. Nil means no
+	// hook.
+	flushHook atomic.Value
+
+	// exitFunc is the function installed with SetExitFunc. Nil means
+	// fatalExitFunc.
+	exitFunc func(code int)
 }
 
 // buffer holds a byte Buffer for reuse. The zero value is ready for use.
 type buffer struct {
 	bytes.Buffer
-	tmp  [64]byte // temporary byte array for creating headers.
-	next *buffer
+	tmp    [64]byte // temporary byte array for creating headers.
+	next   *buffer
+	header int // length of the header at the start of the buffer.
+	// If true, output appends the delta key of -log_delta before the final
+	// newline.
+	delta bool
 }
 
 var logging loggingT
@@ -559,6 +951,8 @@ func (l *loggingT) getBuffer() *buffer {
 		b = new(buffer)
 	} else {
 		b.next = nil
+		b.header = 0
+		b.delta = false
 		b.Reset()
 	}
 	return b
@@ -566,7 +960,7 @@ func (l *loggingT) getBuffer() *buffer {
 
 // putBuffer returns a buffer to the free list.
 func (l *loggingT) putBuffer(b *buffer) {
-	if b.Len() >= 256 {
+	if b.Cap() > MaxPooledBufferSize {
 		// Let big buffers die a natural death.
 		return
 	}
@@ -576,6 +970,12 @@ func (l *loggingT) putBuffer(b *buffer) {
 	l.freeListMu.Unlock()
 }
 
+// MaxPooledBufferSize is the capacity in bytes above which the buffer used
+// for formatting a log entry is not reused, so that a few very long entries
+// do not keep large allocations alive. It must not be changed while
+// logging.
+var MaxPooledBufferSize = 256
+
 var timeNow = time.Now // Stubbed out for testing.
 
 /*
@@ -594,12 +994,17 @@ where the fields are defined as follows:
 	file             The file name
 	line             The line number
 	msg              The user-supplied message
+With -log_caller_func, the short name of the calling function follows the
+line number, as in "file:line func]".
 */
 func (l *loggingT) header(s severity, depth int) (*buffer, string, int) {
 	_, file, line, ok := runtime.Caller(3 + depth)
+	trim, _ := l.callerPrefixTrim.Load().(string)
 	if !ok {
 		file = "???"
 		line = 1
+	} else if trim != "" && strings.HasPrefix(file, trim) {
+		file = file[len(trim):]
 	} else {
 		if slash := strings.LastIndex(file, "/"); slash >= 0 {
 			path := file
@@ -611,7 +1016,41 @@ func (l *loggingT) header(s severity, depth int) (*buffer, string, int) {
 			}
 		}
 	}
-	return l.formatHeader(s, file, line), file, line
+	buf := l.formatHeader(s, file, line)
+	if l.callerFunc && ok && !l.skipHeaders {
+		if l.logfmt {
+			buf.WriteString("func=")
+			buf.WriteString(callerFunc(4 + depth))
+			buf.WriteByte(' ')
+		} else {
+			// Insert the function name before the closing "] ".
+			buf.Truncate(buf.Len() - 2)
+			buf.WriteByte(' ')
+			buf.WriteString(callerFunc(4 + depth))
+			buf.WriteString("] ")
+		}
+		buf.header = buf.Len()
+	}
+	return buf, file, line
+}
+
+// callerFunc returns the name of the function skip frames up the stack
+// without the package path, for example "TestInfo" or "(*T).Run.func1". It
+// uses CallersFrames because FuncForPC does not account for inlining.
+func callerFunc(skip int) string {
+	var pcs [1]uintptr
+	if runtime.Callers(skip+1, pcs[:]) == 0 {
+		return "???"
+	}
+	frame, _ := runtime.CallersFrames(pcs[:]).Next()
+	name := frame.Function
+	if slash := strings.LastIndex(name, "/"); slash >= 0 {
+		name = name[slash+1:]
+	}
+	if dot := strings.Index(name, "."); dot >= 0 {
+		name = name[dot+1:]
+	}
+	return name
 }
 
 // formatHeader formats a log header using the provided file name and line number.
@@ -627,6 +1066,11 @@ func (l *loggingT) formatHeader(s severity, file string, line int) *buffer {
 	if l.skipHeaders {
 		return buf
 	}
+	if l.logfmt {
+		fmt.Fprintf(buf, "level=%s ts=%s caller=%s:%d ", strings.ToLower(severityName[s]), now.Format("2006-01-02T15:04:05.000000Z07:00"), file, line)
+		buf.header = buf.Len()
+		return buf
+	}
 
 	// Avoid Fprintf, for speed. The format is so simple that we can do it quickly by hand.
 	// It's worth about 3X. Fprintf is hard.
@@ -647,13 +1091,19 @@ func (l *loggingT) formatHeader(s severity, file string, line int) *buffer {
 	buf.tmp[21] = ' '
 	buf.nDigits(7, 22, pid, ' ') // TODO: should be TID
 	buf.tmp[29] = ' '
-	buf.Write(buf.tmp[:30])
+	if tokens, _ := l.severityTokens.Load().(*[numSeverity]string); tokens != nil {
+		buf.WriteString(tokens[s])
+		buf.Write(buf.tmp[1:30])
+	} else {
+		buf.Write(buf.tmp[:30])
+	}
 	buf.WriteString(file)
 	buf.tmp[0] = ':'
 	n := buf.someDigits(1, line)
 	buf.tmp[n+1] = ']'
 	buf.tmp[n+2] = ' '
 	buf.Write(buf.tmp[:n+3])
+	buf.header = buf.Len()
 	return buf
 }
 
@@ -776,11 +1226,22 @@ func (l *loggingT) printWithFileLine(s severity, logr logr.Logger, filter LogFil
 
 // if loggr is specified, will call loggr.Error, otherwise output with logging module.
 func (l *loggingT) errorS(err error, loggr logr.Logger, filter LogFilter, depth int, msg string, keysAndValues ...interface{}) {
+	if loggr != nil {
+		keysAndValues = nestGroups(keysAndValues)
+	}
+	keysAndValues = expandKVLists(keysAndValues)
+	l.checkKVs(depth+1, msg, keysAndValues)
+	keysAndValues = mergeErrorFields(err, keysAndValues)
+	if l.errorCauses {
+		if causes := unwrapCauses(err); len(causes) > 0 {
+			keysAndValues = append(keysAndValues[:len(keysAndValues):len(keysAndValues)], "errCauses", causes)
+		}
+	}
 	if filter != nil {
 		msg, keysAndValues = filter.FilterS(msg, keysAndValues)
 	}
 	if loggr != nil {
-		logr.WithCallDepth(loggr, depth+2).Error(err, msg, keysAndValues...)
+		logr.WithCallDepth(loggr, depth+2).Error(err, msg, redactKVs(keysAndValues)...)
 		return
 	}
 	l.printS(err, errorLog, depth+1, msg, keysAndValues...)
@@ -788,32 +1249,102 @@ func (l *loggingT) errorS(err error, loggr logr.Logger, filter LogFilter, depth
 
 // if loggr is specified, will call loggr.Info, otherwise output with logging module.
 func (l *loggingT) infoS(loggr logr.Logger, filter LogFilter, depth int, msg string, keysAndValues ...interface{}) {
+	l.severityS(infoLog, loggr, filter, depth+1, msg, keysAndValues...)
+}
+
+// severityS is infoS for severity s, which must not be errorLog. Loggers
+// implementing SeverityLogger get the severity. After a fatal entry, the
+// process terminates also when a logger is used.
+func (l *loggingT) severityS(s severity, loggr logr.Logger, filter LogFilter, depth int, msg string, keysAndValues ...interface{}) {
+	if loggr != nil {
+		keysAndValues = nestGroups(keysAndValues)
+	}
+	keysAndValues = expandKVLists(keysAndValues)
+	l.checkKVs(depth+1, msg, keysAndValues)
 	if filter != nil {
 		msg, keysAndValues = filter.FilterS(msg, keysAndValues)
 	}
 	if loggr != nil {
-		logr.WithCallDepth(loggr, depth+2).Info(msg, keysAndValues...)
+		keysAndValues = redactKVs(keysAndValues)
+		loggr = logr.WithCallDepth(loggr, depth+2)
+		if sl, ok := loggr.(SeverityLogger); ok {
+			sl.InfoWithSeverity(severityName[s], msg, keysAndValues...)
+		} else {
+			loggr.Info(msg, keysAndValues...)
+		}
+		if s == fatalLog {
+			l.mu.Lock()
+			l.fatal([]byte(msg), false)
+		}
 		return
 	}
-	l.printS(nil, infoLog, depth+1, msg, keysAndValues...)
+	l.printS(nil, s, depth+1, msg, keysAndValues...)
 }
 
 // printS is called from infoS and errorS if loggr is not specified.
 // set log severity by s
 func (l *loggingT) printS(err error, s severity, depth int, msg string, keysAndValues ...interface{}) {
 	b := &bytes.Buffer{}
-	b.WriteString(fmt.Sprintf("%q", msg))
+	if l.logfmt {
+		b.WriteString("msg=")
+	}
+	b.WriteString(strconv.Quote(msg))
 	if err != nil {
 		b.WriteByte(' ')
 		b.WriteString(fmt.Sprintf("err=%q", err.Error()))
 	}
-	kvListFormat(b, keysAndValues...)
-	l.printDepth(s, logging.logr, nil, depth+1, b)
+	// The common case of a message without key/value pairs does not need
+	// any of the formatting in kvListFormat.
+	if len(keysAndValues) > 0 {
+		kvListFormat(b, keysAndValues...)
+	}
+	if l.hostPID {
+		kvListFormat(b, "host", host, "pid", pid)
+	}
+	if l.uptime {
+		kvListFormat(b, "uptime", time.Since(processStart))
+	}
+	buf, file, line := l.header(s, depth)
+	// if logr is set, we clear the generated header as we rely on the backing
+	// logr implementation to print headers
+	if logging.logr != nil {
+		l.putBuffer(buf)
+		buf = l.getBuffer()
+	}
+	buf.Write(b.Bytes())
+	buf.WriteByte('\n')
+	// The delta must be computed under l.mu, together with storing the time
+	// of this entry.
+	buf.delta = true
+	l.output(s, logging.logr, buf, depth, file, line, false)
+}
+
+// checkKVs logs a warning for the same call site if strict mode is enabled
+// and keysAndValues has an odd length.
+func (l *loggingT) checkKVs(depth int, msg string, keysAndValues []interface{}) {
+	if len(keysAndValues)%2 == 0 || atomic.LoadInt32(&l.strictKVs) == 0 {
+		return
+	}
+	l.printS(nil, warningLog, depth+1, "Odd number of arguments passed as key-value pairs for logging", "msg", msg)
+}
+
+// SetStrictKVs enables or disables the strict mode for key/value lists. In
+// strict mode, each structured log call with an odd number of key/value
+// arguments causes an additional warning for the same call site. The entry
+// itself is logged as usual.
+func SetStrictKVs(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&logging.strictKVs, v)
 }
 
 const missingValue = "(MISSING)"
 
 func kvListFormat(b *bytes.Buffer, keysAndValues ...interface{}) {
+	redact, _ := logging.valueRedactor.Load().(func(key string, value interface{}) interface{})
+	formatProto, _ := logging.protoFormatter.Load().(func(m interface{}) (string, bool))
 	for i := 0; i < len(keysAndValues); i += 2 {
 		var v interface{}
 		k := keysAndValues[i]
@@ -822,6 +1353,14 @@ func kvListFormat(b *bytes.Buffer, keysAndValues ...interface{}) {
 		} else {
 			v = missingValue
 		}
+		if redact != nil {
+			v = redact(fmt.Sprint(k), v)
+		}
+		if _, ok := v.(protoMessage); ok && formatProto != nil {
+			if text, ok := formatProto(v); ok {
+				v = text
+			}
+		}
 		b.WriteByte(' ')
 
 		switch v.(type) {
@@ -867,6 +1406,61 @@ func SetLogger(logr logr.Logger) {
 	defer logging.mu.Unlock()
 
 	logging.logr = logr
+	var discard int32
+	if isDiscard(logr) {
+		discard = 1
+	}
+	atomic.StoreInt32(&logging.discard, discard)
+}
+
+func isDiscard(logger logr.Logger) bool {
+	switch logger.(type) {
+	case logr.DiscardLogger, *logr.DiscardLogger:
+		return true
+	}
+	return false
+}
+
+// LoggingEnabled returns false if no log entry can be emitted at all: the
+// verbosity is 0, there are no -vmodule rules and call site overrides, and
+// the logger installed with SetLogger is logr.Discard(). Hot code can use it
+// to skip building log arguments. The check consists of atomic loads only.
+func LoggingEnabled() bool {
+	return atomic.LoadInt32(&logging.discard) == 0 ||
+		logging.verbosity.get() > 0 ||
+		atomic.LoadInt32(&logging.filterLength) > 0 ||
+		atomic.LoadInt32(&logging.callSitesLength) > 0
+}
+
+// SeverityLogger is an optional interface for loggers installed with
+// SetLogger. klog calls InfoWithSeverity instead of Info for the
+// non-error severities, so that the logger can tell warnings apart from
+// informational messages. The severity is "INFO", "WARNING" or "FATAL".
+type SeverityLogger interface {
+	logr.Logger
+
+	InfoWithSeverity(severity string, msg string, keysAndValues ...interface{})
+}
+
+// LoggerInfoProvider is an optional interface for loggers which can report
+// the context that they carry. klogr and NewLoggerToWriter implement it.
+type LoggerInfoProvider interface {
+	logr.Logger
+
+	// LoggerInfo returns the name added with WithName as rendered in the
+	// output, a copy of the values added with WithValues and the
+	// verbosity level added with V.
+	LoggerInfo() (name string, values []interface{}, verbosity int)
+}
+
+// LoggerInfo returns the name, values and verbosity of a logger which
+// implements LoggerInfoProvider, for debugging. For other loggers it
+// returns an empty name, no values and 0.
+func LoggerInfo(logger logr.Logger) (name string, values []interface{}, verbosity int) {
+	if provider, ok := logger.(LoggerInfoProvider); ok {
+		return provider.LoggerInfo()
+	}
+	return "", nil, 0
 }
 
 // SetOutput sets the output destination for all severities
@@ -895,6 +1489,110 @@ func SetOutputBySeverity(name string, w io.Writer) {
 	logging.file[sev] = rb
 }
 
+// SetSeverityTokens replaces the single severity character at the start of
+// each log header with a custom token, keyed by severity name ("INFO",
+// "WARNING", "ERROR" and "FATAL"), for example "INF" or "D". Severities not
+// present in the map keep their default character. Tokens must be non-empty
+// and unique so that the severity can still be parsed from the header. A nil
+// map restores the default "IWEF" characters.
+func SetSeverityTokens(tokens map[string]string) error {
+	if tokens == nil {
+		logging.severityTokens.Store((*[numSeverity]string)(nil))
+		return nil
+	}
+	var resolved [numSeverity]string
+	for s := infoLog; s <= fatalLog; s++ {
+		resolved[s] = severityChar[s : s+1]
+	}
+	for name, token := range tokens {
+		s, ok := severityByName(name)
+		if !ok {
+			return fmt.Errorf("unrecognized severity name %q", name)
+		}
+		if token == "" {
+			return fmt.Errorf("empty token for severity %s", severityName[s])
+		}
+		resolved[s] = token
+	}
+	for s := infoLog; s <= fatalLog; s++ {
+		for other := s + 1; other <= fatalLog; other++ {
+			if resolved[s] == resolved[other] {
+				return fmt.Errorf("severities %s and %s both use token %q", severityName[s], severityName[other], resolved[s])
+			}
+		}
+	}
+	logging.severityTokens.Store(&resolved)
+	return nil
+}
+
+// SetValueRedactor installs a function which is called for each key/value
+// pair of structured log entries before the value is formatted. The value
+// that it returns is logged instead, which allows an application to hide
+// secrets, for example all values whose key contains "password" or "token".
+// The function must be safe for concurrent use and should return value
+// unchanged for keys that it does not handle. Nil removes the redactor.
+//
+// Key/value pairs passed to a logger installed with SetLogger are redacted
+// too, except for those added with WithValues to that logger.
+func SetValueRedactor(redactor func(key string, value interface{}) interface{}) {
+	logging.valueRedactor.Store(redactor)
+}
+
+// redactKVs returns a copy of keysAndValues with the values replaced by
+// the redactor installed with SetValueRedactor, or keysAndValues itself if
+// there is none. The text output is redacted by kvListFormat instead.
+func redactKVs(keysAndValues []interface{}) []interface{} {
+	redact, _ := logging.valueRedactor.Load().(func(key string, value interface{}) interface{})
+	if redact == nil {
+		return keysAndValues
+	}
+	kvs := make([]interface{}, len(keysAndValues))
+	copy(kvs, keysAndValues)
+	for i := 0; i+1 < len(kvs); i += 2 {
+		kvs[i+1] = redact(fmt.Sprint(kvs[i]), kvs[i+1])
+	}
+	return kvs
+}
+
+// protoMessage is the method which all generated protobuf message types
+// have, with the old as well as with the new protobuf API.
+type protoMessage interface {
+	ProtoMessage()
+}
+
+// SetProtoFormatter installs a function which turns protobuf messages into
+// text for the values of structured log entries, for example with
+// prototext.MarshalOptions{}.Format. This keeps klog itself free of a
+// dependency on protobuf. The function is called for all values with a
+// ProtoMessage method. If it returns false, the value is formatted as
+// usual. The function must be safe for concurrent use. Nil removes it.
+//
+// Like SetValueRedactor, this only affects the text output of klog.
+func SetProtoFormatter(formatter func(m interface{}) (string, bool)) {
+	logging.protoFormatter.Store(formatter)
+}
+
+// SetNameRenderer installs a function which turns the names added with
+// WithName to the loggers of klogr and NewLoggerToWriter into the prefix of
+// their messages, for example "[main][helper]" instead of the default
+// "main/helper". The function must be safe for concurrent use. Nil restores
+// the default.
+func SetNameRenderer(renderer func(segments []string) string) {
+	logging.nameRenderer.Store(renderer)
+}
+
+// RenderName returns the prefix for the given logger names as determined by
+// SetNameRenderer. It returns an empty string if there are no names.
+func RenderName(segments []string) string {
+	if len(segments) == 0 {
+		return ""
+	}
+	if render, _ := logging.nameRenderer.Load().(func(segments []string) string); render != nil {
+		return render(segments)
+	}
+	return strings.Join(segments, "/")
+}
+
 // LogToStderr sets whether to log exclusively to stderr, bypassing outputs
 func LogToStderr(stderr bool) {
 	logging.mu.Lock()
@@ -906,119 +1604,390 @@ func LogToStderr(stderr bool) {
 // output writes the data to the log files and releases the buffer.
 func (l *loggingT) output(s severity, log logr.Logger, buf *buffer, depth int, file string, line int, alsoToStderr bool) {
 	l.mu.Lock()
+	var now time.Duration
+	if l.delta {
+		now = time.Since(processStart)
+		if buf.delta {
+			buf.Truncate(buf.Len() - 1)
+			kvListFormat(&buf.Buffer, "delta", now-l.lastOutput)
+			buf.WriteByte('\n')
+		}
+	}
 	if l.traceLocation.isSet() {
 		if l.traceLocation.match(file, line) {
 			buf.Write(stacks(false))
 		}
 	}
-	data := buf.Bytes()
+	if l.logfmt && log == nil {
+		quoteMessage(buf)
+	}
+	if l.dedup && log == nil && s != fatalLog {
+		if l.isRepeat(s, buf, file, line) {
+			l.putBuffer(buf)
+			l.mu.Unlock()
+			return
+		}
+	}
+	if l.delta {
+		l.lastOutput = now
+	}
+	data := l.prefix(buf.Bytes(), log)
+	if l.bufferUntilError && log == nil {
+		if s < errorLog {
+			l.hold(s, data, alsoToStderr)
+			l.putBuffer(buf)
+			l.mu.Unlock()
+			return
+		}
+		l.writeHeld()
+		l.bufferUntilError = false
+	}
 	if log != nil {
 		// TODO: set 'severity' and caller information as structured log info
 		// keysAndValues := []interface{}{"severity", severityName[s], "file", file, "line", line}
 		if s == errorLog {
 			logr.WithCallDepth(l.logr, depth+3).Error(nil, string(data))
+		} else if sl, ok := logr.WithCallDepth(log, depth+3).(SeverityLogger); ok {
+			sl.InfoWithSeverity(severityName[s], string(data))
 		} else {
 			logr.WithCallDepth(log, depth+3).Info(string(data))
 		}
-	} else if l.toStderr {
-		os.Stderr.Write(data)
 	} else {
-		if alsoToStderr || l.alsoToStderr || s >= l.stderrThreshold.get() {
-			os.Stderr.Write(data)
-		}
-
-		if logging.logFile != "" {
-			// Since we are using a single log file, all of the items in l.file array
-			// will point to the same file, so just use one of them to write data.
-			if l.file[infoLog] == nil {
-				if err := l.createFiles(infoLog); err != nil {
-					os.Stderr.Write(data) // Make sure the message appears somewhere.
-					l.exit(err)
-				}
-			}
-			l.file[infoLog].Write(data)
-		} else {
-			if l.file[s] == nil {
-				if err := l.createFiles(s); err != nil {
-					os.Stderr.Write(data) // Make sure the message appears somewhere.
-					l.exit(err)
-				}
-			}
-
-			if l.oneOutput {
-				l.file[s].Write(data)
-			} else {
-				switch s {
-				case fatalLog:
-					l.file[fatalLog].Write(data)
-					fallthrough
-				case errorLog:
-					l.file[errorLog].Write(data)
-					fallthrough
-				case warningLog:
-					l.file[warningLog].Write(data)
-					fallthrough
-				case infoLog:
-					l.file[infoLog].Write(data)
-				}
-			}
-		}
+		l.write(s, data, alsoToStderr)
 	}
 	if s == fatalLog {
-		// If we got here via Exit rather than Fatal, print no stacks.
-		if atomic.LoadUint32(&fatalNoStacks) > 0 {
-			l.mu.Unlock()
-			timeoutFlush(10 * time.Second)
-			os.Exit(1)
-		}
-		// Dump all goroutine stacks before exiting.
-		trace := stacks(true)
-		// Write the stack trace for all goroutines to the stderr.
-		if l.toStderr || l.alsoToStderr || s >= l.stderrThreshold.get() || alsoToStderr {
-			os.Stderr.Write(trace)
-		}
-		// Write the stack trace for all goroutines to the files.
-		logExitFunc = func(error) {} // If we get a write error, we'll still exit below.
-		for log := fatalLog; log >= infoLog; log-- {
-			if f := l.file[log]; f != nil { // Can be nil if -logtostderr is set.
-				f.Write(trace)
-			}
-		}
-		l.mu.Unlock()
-		timeoutFlush(10 * time.Second)
-		os.Exit(255) // C++ uses -1, which is silly because it's anded with 255 anyway.
+		l.fatal(data, alsoToStderr)
+		return
 	}
 	l.putBuffer(buf)
+	rotations, hooks := l.pendingRotations, l.rotateHooks
+	l.pendingRotations = nil
 	l.mu.Unlock()
 	if stats := severityStats[s]; stats != nil {
 		atomic.AddInt64(&stats.lines, 1)
 		atomic.AddInt64(&stats.bytes, int64(len(data)))
 	}
-}
-
-// timeoutFlush calls Flush and returns when it completes or after timeout
-// elapses, whichever happens first.  This is needed because the hooks invoked
-// by Flush may deadlock when klog.Fatal is called from a hook that holds
-// a lock.
-func timeoutFlush(timeout time.Duration) {
-	done := make(chan bool, 1)
-	go func() {
-		Flush() // calls logging.lockAndFlushAll()
-		done <- true
-	}()
-	select {
-	case <-done:
-	case <-time.After(timeout):
-		fmt.Fprintln(os.Stderr, "klog: Flush took longer than", timeout)
+	// The hooks are called without holding mu so that they may log.
+	for _, r := range rotations {
+		for _, hook := range hooks {
+			hook(r.oldPath, r.newPath)
+		}
 	}
 }
 
-// stacks is a wrapper for runtime.Stack that attempts to recover the data for all goroutines.
-func stacks(all bool) []byte {
-	// We don't know how big the traces are, so grow a few times if they don't fit. Start large, though.
-	n := 10000
-	if all {
-		n = 100000
+// fatal terminates the process or panics after the fatal entry data was
+// written, as configured with SetFatalBehavior.
+// l.mu is held and gets unlocked.
+func (l *loggingT) fatal(data []byte, alsoToStderr bool) {
+	// The flag only applies to the entry of the Exit call which set it,
+	// also when the exit function returns or the panic gets recovered.
+	noStacks := atomic.SwapUint32(&fatalNoStacks, 0) > 0
+	if l.fatalBehavior == FatalPanic {
+		l.mu.Unlock()
+		timeoutFlush(10 * time.Second)
+		panic(strings.TrimSuffix(string(data), "\n"))
+	}
+	exit := l.exitFunc
+	if exit == nil {
+		exit = fatalExitFunc
+	}
+	// If we got here via Exit rather than Fatal, print no stacks.
+	if noStacks {
+		l.mu.Unlock()
+		timeoutFlush(10 * time.Second)
+		exit(1)
+		return
+	}
+	// Dump the goroutine stacks selected by -log_fatal_stacks before exiting.
+	var trace []byte
+	switch l.fatalStacks {
+	case fatalStacksAll:
+		trace = stacks(true)
+	case fatalStacksCurrent:
+		trace = stacks(false)
+	}
+	// Write the stack trace to the stderr.
+	if l.toStderr || l.alsoToStderr || fatalLog >= l.stderrThreshold.get() || alsoToStderr {
+		os.Stderr.Write(trace)
+	}
+	// Write the stack trace to the files.
+	logExitFunc = func(error) {} // If we get a write error, we'll still exit below.
+	for log := fatalLog; log >= infoLog; log-- {
+		if f := l.file[log]; f != nil { // Can be nil if -logtostderr is set.
+			f.Write(trace)
+		}
+	}
+	l.mu.Unlock()
+	timeoutFlush(10 * time.Second)
+	exit(255) // C++ uses -1, which is silly because it's anded with 255 anyway.
+}
+
+// prefix applies the prefixes enabled by -log_monotonic and -log_sequence
+// to data, unless the entry goes to a logr backend.
+// l.mu is held.
+func (l *loggingT) prefix(data []byte, log logr.Logger) []byte {
+	if l.monotonic && log == nil {
+		data = l.prefixMonotonic(data)
+	}
+	if l.sequence && log == nil {
+		data = l.prefixSequence(data)
+	}
+	return data
+}
+
+// write writes data to stderr and to the files of the severities which get
+// entries of severity s.
+// l.mu is held.
+func (l *loggingT) write(s severity, data []byte, alsoToStderr bool) {
+	if l.toStderr {
+		os.Stderr.Write(data)
+		return
+	}
+	if alsoToStderr || l.alsoToStderr || s >= l.stderrThreshold.get() {
+		os.Stderr.Write(data)
+	}
+	if s < l.fileThreshold.get() {
+		return
+	}
+
+	if logging.logFile != "" {
+		// Since we are using a single log file, all of the items in l.file array
+		// will point to the same file, so just use one of them to write data.
+		if l.file[infoLog] == nil {
+			if err := l.createFiles(infoLog); err != nil {
+				os.Stderr.Write(data) // Make sure the message appears somewhere.
+				l.exit(err)
+			}
+		}
+		l.file[infoLog].Write(data)
+	} else {
+		if l.file[s] == nil {
+			if err := l.createFiles(s); err != nil {
+				os.Stderr.Write(data) // Make sure the message appears somewhere.
+				l.exit(err)
+			}
+		}
+
+		if l.oneOutput {
+			l.file[s].Write(data)
+		} else {
+			switch s {
+			case fatalLog:
+				l.file[fatalLog].Write(data)
+				fallthrough
+			case errorLog:
+				l.file[errorLog].Write(data)
+				fallthrough
+			case warningLog:
+				l.file[warningLog].Write(data)
+				fallthrough
+			case infoLog:
+				l.file[infoLog].Write(data)
+			}
+		}
+	}
+}
+
+// quoteMessage turns the text after the header into a msg key/value pair
+// for -log_logfmt, unless it already is one because it was produced by a
+// structured logging call.
+func quoteMessage(buf *buffer) {
+	body := buf.Bytes()[buf.header:]
+	if bytes.HasPrefix(body, []byte(`msg="`)) {
+		return
+	}
+	msg := strconv.Quote(strings.TrimSuffix(string(body), "\n"))
+	buf.Truncate(buf.header)
+	buf.WriteString("msg=")
+	buf.WriteString(msg)
+	buf.WriteByte('\n')
+}
+
+// heldEntry is an entry held back by SetBufferUntilError.
+type heldEntry struct {
+	s            severity
+	data         []byte
+	alsoToStderr bool
+}
+
+// maxHeldEntries limits the number of entries held back by
+// SetBufferUntilError.
+const maxHeldEntries = 10000
+
+// hold keeps a copy of data until writeHeld is called. When maxHeldEntries
+// is reached, the oldest entry is dropped.
+// l.mu is held.
+func (l *loggingT) hold(s severity, data []byte, alsoToStderr bool) {
+	entry := heldEntry{s: s, data: append([]byte(nil), data...), alsoToStderr: alsoToStderr}
+	if len(l.held) < maxHeldEntries {
+		l.held = append(l.held, entry)
+		return
+	}
+	l.held[l.heldStart] = entry
+	l.heldStart = (l.heldStart + 1) % len(l.held)
+}
+
+// writeHeld writes and forgets the entries held back by SetBufferUntilError.
+// l.mu is held.
+func (l *loggingT) writeHeld() {
+	for i := range l.held {
+		entry := l.held[(l.heldStart+i)%len(l.held)]
+		l.write(entry.s, entry.data, entry.alsoToStderr)
+	}
+	l.held = nil
+	l.heldStart = 0
+}
+
+// SetBufferUntilError enables or disables the "quiet until error" mode,
+// which is useful for command line tools. While enabled, INFO and WARNING
+// entries are kept in memory instead of being written. The first ERROR or
+// FATAL entry writes them, followed by itself, and ends the mode, so all
+// further entries are written directly. Flush and FlushE also write the
+// held entries, but keep the mode enabled; the periodic flushes of the flush
+// daemon do not. Disabling the mode discards the held entries. At most
+// 10000 entries are held; beyond that, the oldest ones are dropped. The
+// summaries of -log_dedup are held like the entries that they summarize.
+//
+// Entries passed to a logger installed with SetLogger are not affected.
+func SetBufferUntilError(enabled bool) {
+	logging.mu.Lock()
+	defer logging.mu.Unlock()
+	logging.bufferUntilError = enabled
+	logging.held = nil
+	logging.heldStart = 0
+}
+
+// repeatState tracks the last entry of a severity for -log_dedup.
+type repeatState struct {
+	body    string
+	file    string
+	line    int
+	repeats int
+}
+
+// isRepeat reports whether the entry in data is identical to the previous
+// entry of the same severity, ignoring the header. Otherwise the summary for
+// the previous entry is written if it was repeated, and the entry becomes
+// the one that later entries are compared against.
+// l.mu is held.
+func (l *loggingT) isRepeat(s severity, buf *buffer, file string, line int) bool {
+	body := buf.Bytes()[buf.header:]
+	last := &l.repeats[s]
+	if last.body != "" && last.body == string(body) {
+		last.repeats++
+		return true
+	}
+	l.writeRepeats(s)
+	*last = repeatState{body: string(body), file: file, line: line}
+	return false
+}
+
+// writeRepeats writes the summary for the last entry of severity s if it
+// was repeated.
+// l.mu is held.
+func (l *loggingT) writeRepeats(s severity) {
+	last := &l.repeats[s]
+	if last.repeats == 0 {
+		return
+	}
+	buf := l.formatHeader(s, last.file, last.line)
+	fmt.Fprintf(buf, "(last message repeated %d times)\n", last.repeats)
+	last.repeats = 0
+	data := l.prefix(buf.Bytes(), nil)
+	if l.bufferUntilError && s < errorLog {
+		l.hold(s, data, false)
+	} else {
+		l.write(s, data, false)
+	}
+	l.putBuffer(buf)
+}
+
+// rotation records the file names involved in a log file rotation.
+type rotation struct {
+	oldPath, newPath string
+}
+
+// OnRotate registers a callback which gets invoked after a log file was
+// rotated successfully, with the names of the old and the new file. Log
+// shippers can use this to start tracking the new file. Callbacks are
+// invoked in the order in which they were registered by the goroutine whose
+// log call triggered the rotation, without holding internal locks, so they
+// may log themselves. They are not invoked for the files created initially.
+func OnRotate(callback func(oldPath, newPath string)) {
+	logging.mu.Lock()
+	defer logging.mu.Unlock()
+	logging.rotateHooks = append(logging.rotateHooks, callback)
+}
+
+// processStart is used to compute monotonic timestamps.
+var processStart = time.Now()
+
+// prefixMonotonic returns data prefixed with the number of nanoseconds since
+// processStart. The value is taken from the monotonic clock and bumped if
+// necessary so that it is strictly larger than the previous one, which makes
+// it usable for ordering lines even when their timestamps are equal.
+// l.mu is held.
+func (l *loggingT) prefixMonotonic(data []byte) []byte {
+	now := int64(time.Since(processStart))
+	if now <= l.lastMonotonic {
+		now = l.lastMonotonic + 1
+	}
+	l.lastMonotonic = now
+	prefixed := make([]byte, 0, len(data)+21)
+	prefixed = strconv.AppendInt(prefixed, now, 10)
+	prefixed = append(prefixed, ' ')
+	return append(prefixed, data...)
+}
+
+// prefixSequence returns data prefixed with the next sequence number. The
+// numbers are shared by all severities and start at 1, so gaps reveal lines
+// which got lost after klog wrote them.
+// l.mu is held.
+func (l *loggingT) prefixSequence(data []byte) []byte {
+	l.lastSequence++
+	prefixed := make([]byte, 0, len(data)+21)
+	prefixed = strconv.AppendUint(prefixed, l.lastSequence, 10)
+	prefixed = append(prefixed, ' ')
+	return append(prefixed, data...)
+}
+
+// timeoutFlush calls Flush and returns when it completes or after timeout
+// elapses, whichever happens first.  This is needed because the hooks invoked
+// by Flush may deadlock when klog.Fatal is called from a hook that holds
+// a lock.
+func timeoutFlush(timeout time.Duration) {
+	if err := FlushWithTimeout(timeout); err != nil {
+		fmt.Fprintln(os.Stderr, "klog: Flush took longer than", timeout)
+	}
+}
+
+// FlushWithTimeout is like Flush but returns context.DeadlineExceeded if
+// flushing does not complete within timeout, for example because a log file
+// or output writer is stuck. The flush itself is not aborted: it continues
+// in the background under the same lock as every other write, so no log
+// data is lost or corrupted if the writer recovers later.
+func FlushWithTimeout(timeout time.Duration) error {
+	done := make(chan struct{})
+	go func() {
+		Flush() // calls logging.lockAndFlushAll()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return nil
+	case <-timer.C:
+		return context.DeadlineExceeded
+	}
+}
+
+// stacks is a wrapper for runtime.Stack that attempts to recover the data for all goroutines.
+func stacks(all bool) []byte {
+	// We don't know how big the traces are, so grow a few times if they don't fit. Start large, though.
+	n := 10000
+	if all {
+		n = 100000
 	}
 	var trace []byte
 	for i := 0; i < 5; i++ {
@@ -1038,6 +2007,42 @@ func stacks(all bool) []byte {
 // would make its use clumsier.
 var logExitFunc func(error)
 
+// FatalBehavior determines what Fatal, Exit and their variants do after
+// writing the log entry.
+type FatalBehavior int
+
+const (
+	// FatalExit terminates the process. This is the default.
+	FatalExit FatalBehavior = iota
+	// FatalPanic flushes the logs and then panics with the log entry as a
+	// string, which allows callers to recover.
+	FatalPanic
+)
+
+// SetFatalBehavior changes what Fatal, Exit and their variants do after
+// writing the log entry. Libraries which are embedded in larger programs and
+// tests can use FatalPanic to avoid terminating the process.
+func SetFatalBehavior(behavior FatalBehavior) {
+	logging.mu.Lock()
+	defer logging.mu.Unlock()
+	logging.fatalBehavior = behavior
+}
+
+// fatalExitFunc terminates the process after a fatal log entry was written.
+// Tests replace it to observe the exit code instead of exiting.
+var fatalExitFunc = os.Exit
+
+// SetExitFunc replaces os.Exit as the function which Fatal, Exit and their
+// variants call with the exit code after the log entry was written and
+// the logs were flushed. Tests can use it to observe the exit code. The
+// function should not return; if it does, the logging call returns. Nil
+// restores os.Exit.
+func SetExitFunc(exit func(code int)) {
+	logging.mu.Lock()
+	defer logging.mu.Unlock()
+	logging.exitFunc = exit
+}
+
 // exit is called if there is trouble creating or writing log files.
 // It flushes the logs and exits the program; there's no point in hanging around.
 // l.mu is held.
@@ -1061,8 +2066,9 @@ type syncBuffer struct {
 	*bufio.Writer
 	file     *os.File
 	sev      severity
-	nbytes   uint64 // The number of bytes written to this file
-	maxbytes uint64 // The max number of bytes this syncBuffer.file can hold before cleaning up.
+	nbytes   uint64    // The number of bytes written to this file
+	maxbytes uint64    // The max number of bytes this syncBuffer.file can hold before cleaning up.
+	created  time.Time // When this syncBuffer.file was opened.
 }
 
 func (sb *syncBuffer) Sync() error {
@@ -1084,8 +2090,9 @@ func CalculateMaxSize() uint64 {
 }
 
 func (sb *syncBuffer) Write(p []byte) (n int, err error) {
-	if sb.nbytes+uint64(len(p)) >= sb.maxbytes {
-		if err := sb.rotateFile(time.Now(), false); err != nil {
+	now := timeNow()
+	if sb.nbytes+uint64(len(p)) >= sb.maxbytes || sb.expired(now) {
+		if err := sb.rotateFile(now, false); err != nil {
 			sb.logger.exit(err)
 		}
 	}
@@ -1097,19 +2104,40 @@ func (sb *syncBuffer) Write(p []byte) (n int, err error) {
 	return
 }
 
+// expired reports whether the file is older than the -log_file_max_age limit.
+func (sb *syncBuffer) expired(now time.Time) bool {
+	maxAge := sb.logger.logFileMaxAge
+	return maxAge > 0 && now.Sub(sb.created) >= maxAge
+}
+
 // rotateFile closes the syncBuffer's file and starts a new one.
 // The startup argument indicates whether this is the initial startup of klog.
 // If startup is true, existing files are opened for appending instead of truncated.
 func (sb *syncBuffer) rotateFile(now time.Time, startup bool) error {
+	var oldName string
 	if sb.file != nil {
 		sb.Flush()
 		sb.file.Close()
+		oldName = sb.file.Name()
 	}
 	var err error
-	sb.file, _, err = create(severityName[sb.sev], now, startup)
+	var fname string
+	sb.file, fname, err = create(severityName[sb.sev], now, startup)
 	if err != nil {
 		return err
 	}
+	sb.created = now
+	// Rotating twice within the same second reopens the same file, which then
+	// must not be compressed.
+	if sb.logger.logFileCompress && sb.logger.logFile == "" && oldName != "" && oldName != fname {
+		startCompressLogFile(oldName)
+	}
+	if oldName != "" && len(sb.logger.rotateHooks) > 0 {
+		sb.logger.pendingRotations = append(sb.logger.pendingRotations, rotation{oldPath: oldName, newPath: fname})
+	}
+	if sb.logger.logFileMaxCount > 0 && sb.logger.logFile == "" && sb.logger.logFilePattern == "" {
+		removeOldLogs(filepath.Dir(fname), severityName[sb.sev], filepath.Base(fname), sb.logger.logFileMaxCount)
+	}
 	if startup {
 		fileInfo, err := sb.file.Stat()
 		if err != nil {
@@ -1145,7 +2173,7 @@ const bufferSize = 256 * 1024
 // createFiles creates all the log files for severity from sev down to infoLog.
 // l.mu is held.
 func (l *loggingT) createFiles(sev severity) error {
-	now := time.Now()
+	now := timeNow()
 	// Files are created in decreasing severity order, so as soon as we find one
 	// has already been created, we can stop.
 	for s := sev; s >= infoLog && l.file[s] == nil; s-- {
@@ -1162,33 +2190,277 @@ func (l *loggingT) createFiles(sev severity) error {
 	return nil
 }
 
+// LogFilePath returns the absolute path of the file that log entries of the
+// given severity ("INFO", "WARNING", "ERROR" or "FATAL") are currently written
+// to. With -log_file, that is the same file for all severities. It returns
+// false if the severity is unknown, if logging goes to stderr only or if the
+// file has not been created yet because nothing was logged.
+func LogFilePath(severity string) (string, bool) {
+	s, ok := severityByName(severity)
+	if !ok {
+		return "", false
+	}
+	logging.mu.Lock()
+	defer logging.mu.Unlock()
+	if logging.toStderr {
+		return "", false
+	}
+	// With -log_file, entries of all severities go to the file of infoLog.
+	if logging.logFile != "" {
+		s = infoLog
+	}
+	sb, ok := logging.file[s].(*syncBuffer)
+	if !ok || sb.file == nil {
+		return "", false
+	}
+	path, err := filepath.Abs(sb.file.Name())
+	if err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// ReopenLogFiles closes the log files and opens them again under the same
+// names, creating them if they were moved away. This is needed after an
+// external tool like logrotate renamed the files, because klog would
+// otherwise continue to write to the renamed files. Buffered entries are
+// written to the old files first. See also HandleSIGHUP.
+func ReopenLogFiles() error {
+	logging.mu.Lock()
+	defer logging.mu.Unlock()
+	var errs []string
+	for s := fatalLog; s >= infoLog; s-- {
+		sb, ok := logging.file[s].(*syncBuffer)
+		if !ok || sb.file == nil {
+			continue
+		}
+		if err := sb.reopen(); err != nil {
+			errs = append(errs, fmt.Sprintf("reopen %s: %v", severityName[s], err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// reopen replaces the file with a new one for the same name. The old file
+// is kept if that fails.
+// sb.logger.mu is held.
+func (sb *syncBuffer) reopen() error {
+	f, err := openOrCreate(sb.file.Name(), true)
+	if err != nil {
+		return err
+	}
+	fileInfo, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	sb.Flush() // ignore error
+	sb.file.Close()
+	sb.file = f
+	sb.nbytes = uint64(fileInfo.Size())
+	sb.Writer = bufio.NewWriterSize(f, bufferSize)
+	return nil
+}
+
 const flushInterval = 5 * time.Second
 
-// flushDaemon periodically flushes the log file buffers.
-func (l *loggingT) flushDaemon() {
-	for range time.NewTicker(flushInterval).C {
-		l.lockAndFlushAll()
+// flushDaemon periodically flushes the log file buffers. It also implements
+// the flag.Value interface for the -log_flush_frequency flag.
+type flushDaemon struct {
+	mu       sync.Mutex
+	flush    func()
+	interval time.Duration
+	stopC    chan struct{}
+	stopDone chan struct{}
+}
+
+// newFlushDaemon returns a new flushDaemon which calls flush periodically
+// once it is started with run.
+func newFlushDaemon(flush func()) *flushDaemon {
+	return &flushDaemon{
+		flush:    flush,
+		interval: flushInterval,
+	}
+}
+
+// run starts a goroutine that periodically calls the daemon's flush function.
+// Calling run on an already running daemon will have no effect.
+func (f *flushDaemon) run(interval time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.runLocked(interval)
+}
+
+// runLocked is like run but must be called with f.mu held.
+func (f *flushDaemon) runLocked(interval time.Duration) {
+	if f.stopC != nil { // daemon already running
+		return
+	}
+
+	f.interval = interval
+	f.stopC = make(chan struct{}, 1)
+	f.stopDone = make(chan struct{}, 1)
+
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		defer func() { f.stopDone <- struct{}{} }()
+		for {
+			select {
+			case <-ticker.C:
+				f.flush()
+			case <-f.stopC:
+				f.flush()
+				return
+			}
+		}
+	}()
+}
+
+// stop stops the running flushDaemon and waits until the daemon has shut down.
+// Calling stop on a daemon that isn't running will have no effect.
+func (f *flushDaemon) stop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stopLocked()
+}
+
+// stopLocked is like stop but must be called with f.mu held.
+func (f *flushDaemon) stopLocked() {
+	if f.stopC == nil { // daemon not running
+		return
+	}
+
+	f.stopC <- struct{}{}
+	<-f.stopDone
+
+	f.stopC = nil
+	f.stopDone = nil
+}
+
+// isRunning returns true if the flush daemon is running.
+func (f *flushDaemon) isRunning() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.stopC != nil
+}
+
+// String is part of the flag.Value interface.
+func (f *flushDaemon) String() string {
+	if f == nil {
+		return flushInterval.String()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.interval.String()
+}
+
+// Get is part of the flag.Getter interface.
+func (f *flushDaemon) Get() interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.interval
+}
+
+// Set is part of the flag.Value interface. If the daemon is running, it is
+// restarted with the new interval, otherwise the interval is stored for
+// later.
+func (f *flushDaemon) Set(value string) error {
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	if interval <= 0 {
+		return errors.New("flush frequency must be positive")
 	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.stopC == nil {
+		f.interval = interval
+		return nil
+	}
+	f.stopLocked()
+	f.runLocked(interval)
+	return nil
+}
+
+// StopFlushDaemon stops the flush daemon, if running, after flushing once.
+// This prevents klog from leaking goroutines on shutdown, for example in
+// tests that check for leaked goroutines. After stopping the daemon, buffers
+// can still be flushed manually by calling Flush. Calling it again has no
+// effect.
+func StopFlushDaemon() {
+	logging.flushD.stop()
+}
+
+// StartFlushDaemon ensures that the flush daemon runs with the given delay
+// between flush calls. If it is already running, it gets restarted.
+func StartFlushDaemon(interval time.Duration) {
+	StopFlushDaemon()
+	logging.flushD.run(interval)
 }
 
 // lockAndFlushAll is like flushAll but locks l.mu first.
 func (l *loggingT) lockAndFlushAll() {
 	l.mu.Lock()
-	l.flushAll()
+	l.flushAll() // ignore error
+	l.mu.Unlock()
+	l.runFlushHook()
+}
+
+// lockAndFlushDaemon is the flush function of the flush daemon. It is like
+// lockAndFlushAll but leaves the entries held back by SetBufferUntilError
+// alone: only explicit flushes write them.
+func (l *loggingT) lockAndFlushDaemon() {
+	l.mu.Lock()
+	l.flushOutputs(false) // ignore error
 	l.mu.Unlock()
+	l.runFlushHook()
 }
 
 // flushAll flushes all the logs and attempts to "sync" their data to disk.
+// The returned error combines the errors of all outputs.
+// l.mu is held.
+func (l *loggingT) flushAll() error {
+	return l.flushOutputs(true)
+}
+
+// flushOutputs implements flushAll. Held entries are only written if
+// writeHeld is true.
 // l.mu is held.
-func (l *loggingT) flushAll() {
+func (l *loggingT) flushOutputs(writeHeld bool) error {
+	if l.dedup {
+		for s := infoLog; s < fatalLog; s++ {
+			l.writeRepeats(s)
+		}
+	}
+	if writeHeld {
+		l.writeHeld()
+	}
+	var errs []string
+	flushed := map[flushSyncWriter]bool{}
 	// Flush from fatal down, in case there's trouble flushing.
 	for s := fatalLog; s >= infoLog; s-- {
 		file := l.file[s]
-		if file != nil {
-			file.Flush() // ignore error
-			file.Sync()  // ignore error
+		// Several severities share the same output with -log_file.
+		if file == nil || flushed[file] {
+			continue
 		}
+		flushed[file] = true
+		if err := file.Flush(); err != nil {
+			errs = append(errs, fmt.Sprintf("flush %s: %v", severityName[s], err))
+		}
+		if err := file.Sync(); err != nil {
+			errs = append(errs, fmt.Sprintf("sync %s: %v", severityName[s], err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
 	}
+	return nil
 }
 
 // CopyStandardLogTo arranges for messages written to the Go "log" package's
@@ -1239,6 +2511,129 @@ func (lb logBridge) Write(b []byte) (n int, err error) {
 	return len(b), nil
 }
 
+// NewWriter returns an io.Writer which logs each line of text written to it
+// as a separate entry with the named severity. The entries are attributed to
+// the code depth frames above the caller of Write: 0 is the caller itself, 2
+// is the caller of log.Printf when the writer is the output of the standard
+// "log" package. The trailing newline of the text is optional.
+//
+// Valid names are "INFO", "WARNING", "ERROR", and "FATAL".  If the name is not
+// recognized, NewWriter panics.
+func NewWriter(name string, depth int) io.Writer {
+	sev, ok := severityByName(name)
+	if !ok {
+		panic(fmt.Sprintf("klog.NewWriter(%q): unrecognized severity name", name))
+	}
+	return writer{sev: sev, depth: depth}
+}
+
+// NewWriterWithValues returns an io.Writer which logs each line of text
+// written to it like InfoS, with the line as message and the given
+// key/value pairs, but with the named severity. The caller of Write is
+// recorded as the source of the entries. It panics for unknown severity
+// names, like NewWriter.
+//
+// Basic example:
+// >> w := klog.NewWriterWithValues("WARNING", "component", "legacy")
+// >> w.Write([]byte("disk almost full\n"))
+// output:
+// >> W1025 00:15:15.525108       1 legacy.go:42] "disk almost full" component="legacy"
+func NewWriterWithValues(name string, keysAndValues ...interface{}) io.Writer {
+	sev, ok := severityByName(name)
+	if !ok {
+		panic(fmt.Sprintf("klog.NewWriterWithValues(%q): unrecognized severity name", name))
+	}
+	return writer{sev: sev, values: expandKVLists(keysAndValues), structured: true}
+}
+
+// writer implements NewWriter, NewWriterWithValues and
+// CaptureStandardLogging.
+type writer struct {
+	sev   severity
+	depth int
+	// If true, sev is only the default and the severity is inferred from
+	// the beginning of each line.
+	infer bool
+	// If true, each line is logged as a structured entry with values.
+	structured bool
+	values     []interface{}
+}
+
+func (w writer) Write(p []byte) (n int, err error) {
+	text := strings.TrimSuffix(string(p), "\n")
+	if text == "" {
+		return len(p), nil
+	}
+	for _, line := range strings.Split(text, "\n") {
+		sev := w.sev
+		if w.infer {
+			sev = inferSeverity(line, sev)
+		}
+		if w.structured {
+			// The filter may modify the slice, so it gets a copy.
+			values := append([]interface{}(nil), w.values...)
+			if sev == errorLog {
+				logging.errorS(nil, logging.logr, logging.filter, w.depth, line, values...)
+				continue
+			}
+			logging.severityS(sev, logging.logr, logging.filter, w.depth, line, values...)
+			continue
+		}
+		logging.printDepth(sev, logging.logr, logging.filter, w.depth, line)
+	}
+	return len(p), nil
+}
+
+// severityPrefixes maps common, lower-case line prefixes to the severity they
+// indicate. Fatal is deliberately absent: a log line must not terminate the
+// process.
+var severityPrefixes = []struct {
+	prefix string
+	sev    severity
+}{
+	{"[error]", errorLog},
+	{"error:", errorLog},
+	{"err:", errorLog},
+	{"[warn]", warningLog},
+	{"[warning]", warningLog},
+	{"warn:", warningLog},
+	{"warning:", warningLog},
+	{"[info]", infoLog},
+	{"info:", infoLog},
+}
+
+// inferSeverity returns the severity indicated by the beginning of line, or
+// def if there is no known prefix.
+func inferSeverity(line string, def severity) severity {
+	line = strings.ToLower(strings.TrimSpace(line))
+	for _, p := range severityPrefixes {
+		if strings.HasPrefix(line, p.prefix) {
+			return p.sev
+		}
+	}
+	return def
+}
+
+// CaptureStandardLogging redirects the output of the standard "log" package
+// to klog, instead of copying it like CopyStandardLogTo. Lines are logged at
+// INFO severity unless they start with a common prefix like "ERROR:",
+// "[warn]" or "Warning:" (case-insensitive), which selects ERROR or WARNING.
+// The flags of the standard logger are cleared because klog adds its own
+// header with time stamp and caller.
+//
+// The returned function restores the previous output and flags of the
+// standard logger.
+func CaptureStandardLogging() (restore func()) {
+	output, flags := stdLog.Writer(), stdLog.Flags()
+	stdLog.SetFlags(0)
+	// 2 skips the standard logger's Output and its caller like log.Printf.
+	stdLog.SetOutput(writer{sev: infoLog, depth: 2, infer: true})
+	return func() {
+		stdLog.SetOutput(output)
+		stdLog.SetFlags(flags)
+	}
+}
+
 // setV computes and remembers the V level for a given PC
 // when vmodule is enabled.
 // File pattern matching takes the basename of the file, stripped
@@ -1246,8 +2641,11 @@ func (lb logBridge) Write(b []byte) (n int, err error) {
 // general than the *? matching used in C++.
 // l.mu is held.
 func (l *loggingT) setV(pc uintptr) Level {
-	fn := runtime.FuncForPC(pc)
-	file, _ := fn.FileLine(pc)
+	// V and VContext only call vDepth and thus may get inlined into their
+	// caller. CallersFrames then still finds the caller's file for pc,
+	// FuncForPC would find klog.go.
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	file := frame.File
 	// The file is something like /a/b/c/d.go. We want just the d.
 	if strings.HasSuffix(file, ".go") {
 		file = file[:len(file)-3]
@@ -1265,19 +2663,99 @@ func (l *loggingT) setV(pc uintptr) Level {
 	return 0
 }
 
+// callSite identifies a source code line by file base name and line number.
+type callSite struct {
+	file string
+	line int
+}
+
+// callSiteLevel is the cached result of looking up a call site override.
+type callSiteLevel struct {
+	level Level
+	ok    bool
+}
+
+// SetCallSiteVerbosity overrides the verbosity for the V call at the given
+// source location. Like for -log_backtrace_at, file is the base name of the
+// source file including the ".go" suffix. The override takes precedence
+// over -v and -vmodule, so it can both enable and silence a single log
+// statement. It panics if line is not positive.
+func SetCallSiteVerbosity(file string, line int, v Level) {
+	if line <= 0 {
+		panic(fmt.Sprintf("SetCallSiteVerbosity(%q, %d): line must be positive", file, line))
+	}
+	logging.mu.Lock()
+	defer logging.mu.Unlock()
+	callSites := make(map[callSite]Level, len(logging.callSites)+1)
+	for site, level := range logging.callSites {
+		callSites[site] = level
+	}
+	callSites[callSite{file, line}] = v
+	logging.setCallSites(callSites)
+}
+
+// UnsetCallSiteVerbosity removes an override set with SetCallSiteVerbosity.
+func UnsetCallSiteVerbosity(file string, line int) {
+	logging.mu.Lock()
+	defer logging.mu.Unlock()
+	callSites := make(map[callSite]Level, len(logging.callSites))
+	for site, level := range logging.callSites {
+		if site != (callSite{file, line}) {
+			callSites[site] = level
+		}
+	}
+	logging.setCallSites(callSites)
+}
+
+// setCallSites installs a new set of call site overrides.
+// l.mu is held.
+func (l *loggingT) setCallSites(callSites map[callSite]Level) {
+	// Disable the lookup in V while we are in transition.
+	atomic.StoreInt32(&l.callSitesLength, 0)
+	l.callSites = callSites
+	l.csmap = make(map[uintptr]callSiteLevel)
+	atomic.StoreInt32(&l.callSitesLength, int32(len(callSites)))
+}
+
+// callSiteV returns the override for the caller of V, if there is one.
+// depth is the number of stack frames between the caller of callSiteV
+// and the caller of V.
+func (l *loggingT) callSiteV(depth int) (Level, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if runtime.Callers(3+depth, l.pcs[:]) == 0 {
+		return 0, false
+	}
+	pc := l.pcs[0]
+	if cached, ok := l.csmap[pc]; ok {
+		return cached.level, cached.ok
+	}
+	frame, _ := runtime.CallersFrames(l.pcs[:]).Next()
+	file := frame.File
+	if slash := strings.LastIndex(file, "/"); slash >= 0 {
+		file = file[slash+1:]
+	}
+	level, ok := l.callSites[callSite{file, frame.Line}]
+	l.csmap[pc] = callSiteLevel{level, ok}
+	return level, ok
+}
+
 // Verbose is a boolean type that implements Infof (like Printf) etc.
 // See the documentation of V for more information.
 type Verbose struct {
 	enabled bool
-	logr    logr.Logger
-	filter  LogFilter
+	// threshold is the highest level enabled for the call site.
+	threshold Level
+	logr      logr.Logger
+	filter    LogFilter
 }
 
-func newVerbose(level Level, b bool) Verbose {
+func newVerbose(level, threshold Level) Verbose {
+	b := threshold >= level
 	if logging.logr == nil {
-		return Verbose{b, nil, logging.filter}
+		return Verbose{b, threshold, nil, logging.filter}
 	}
-	return Verbose{b, logging.logr.V(int(level)), logging.filter}
+	return Verbose{b, threshold, logging.logr.V(int(level)), logging.filter}
 }
 
 // V reports whether verbosity at the call site is at least the requested level.
@@ -1294,14 +2772,44 @@ func newVerbose(level Level, b bool) Verbose {
 // the -v and -vmodule flags; both are off by default. The V call will log if its level
 // is less than or equal to the value of the -v flag, or alternatively if its level is
 // less than or equal to the value of the -vmodule pattern matching the source file
-// containing the call.
+// containing the call. An override set with SetCallSiteVerbosity for the line
+// containing the call takes precedence over both flags.
 func V(level Level) Verbose {
+	return vDepth(0, level)
+}
+
+// VContext is like V, except that the logger stored in ctx with
+// logr.NewContext, if there is one, decides whether the level is enabled and
+// receives the output. This way, a per-request logger with its own verbosity
+// can be used with the methods of Verbose. Without such a logger, VContext
+// behaves exactly like V.
+func VContext(ctx context.Context, level Level) Verbose {
+	if ctx != nil {
+		if logger := logr.FromContext(ctx); logger != nil {
+			logger = logger.V(int(level))
+			return Verbose{logger.Enabled(), level, logger, logging.filter}
+		}
+	}
+	return vDepth(0, level)
+}
+
+// vDepth implements V. depth is the number of stack frames between the
+// caller of V or VContext and the function calling vDepth.
+func vDepth(depth int, level Level) Verbose {
 	// This function tries hard to be cheap unless there's work to do.
-	// The fast path is two atomic loads and compares.
+	// The fast path is three atomic loads and compares.
+
+	// Call site overrides take precedence over everything else.
+	if atomic.LoadInt32(&logging.callSitesLength) > 0 {
+		if v, ok := logging.callSiteV(depth + 1); ok {
+			return newVerbose(level, v)
+		}
+	}
 
 	// Here is a cheap but safe test to see if V logging is enabled globally.
-	if logging.verbosity.get() >= level {
-		return newVerbose(level, true)
+	verbosity := logging.verbosity.get()
+	if verbosity >= level {
+		return newVerbose(level, verbosity)
 	}
 
 	// It's off globally but vmodule may still be set.
@@ -1312,16 +2820,19 @@ func V(level Level) Verbose {
 		// but if V logging is enabled we're slow anyway.
 		logging.mu.Lock()
 		defer logging.mu.Unlock()
-		if runtime.Callers(2, logging.pcs[:]) == 0 {
-			return newVerbose(level, false)
+		if runtime.Callers(3+depth, logging.pcs[:]) == 0 {
+			return newVerbose(level, verbosity)
 		}
 		v, ok := logging.vmap[logging.pcs[0]]
 		if !ok {
 			v = logging.setV(logging.pcs[0])
 		}
-		return newVerbose(level, v >= level)
+		if v < verbosity {
+			v = verbosity
+		}
+		return newVerbose(level, v)
 	}
-	return newVerbose(level, false)
+	return newVerbose(level, verbosity)
 }
 
 // Enabled will return true if this log level is enabled, guarded by the value
@@ -1331,6 +2842,18 @@ func (v Verbose) Enabled() bool {
 	return v.enabled
 }
 
+// Level returns the effective verbosity level for the call site together
+// with the result of Enabled: the level set with SetCallSiteVerbosity for
+// it, otherwise the higher one of -v and the matching -vmodule setting.
+// If -v alone enables the requested level, -vmodule is not checked and the
+// -v level is returned. For a logger stored in the
+// context passed to VContext, the effective level is unknown and the
+// requested level is returned. This avoids a second V call when code needs
+// both, for example to precompute values depending on the level.
+func (v Verbose) Level() (Level, bool) {
+	return v.threshold, v.enabled
+}
+
 // Info is equivalent to the global Info function, guarded by the value of v.
 // See the documentation of V for usage.
 func (v Verbose) Info(args ...interface{}) {
@@ -1355,6 +2878,20 @@ func (v Verbose) Infof(format string, args ...interface{}) {
 	}
 }
 
+// InfofFunc is like Infof, but gets the format and arguments from fn, which
+// is only called if v is enabled. It avoids building expensive arguments
+// for disabled log levels:
+//
+//	klog.V(4).InfofFunc(func() (string, []interface{}) {
+//		return "Pods: %v", []interface{}{listPods()}
+//	})
+func (v Verbose) InfofFunc(fn func() (format string, args []interface{})) {
+	if v.enabled {
+		format, args := fn()
+		logging.printf(infoLog, v.logr, v.filter, format, args...)
+	}
+}
+
 // InfoS is equivalent to the global InfoS function, guarded by the value of v.
 // See the documentation of V for usage.
 func (v Verbose) InfoS(msg string, keysAndValues ...interface{}) {
@@ -1363,6 +2900,14 @@ func (v Verbose) InfoS(msg string, keysAndValues ...interface{}) {
 	}
 }
 
+// InfoSDepth is equivalent to the global InfoSDepth function, guarded by the value of v.
+// See the documentation of V for usage.
+func (v Verbose) InfoSDepth(depth int, msg string, keysAndValues ...interface{}) {
+	if v.enabled {
+		logging.infoS(v.logr, v.filter, depth, msg, keysAndValues...)
+	}
+}
+
 // InfoSDepth acts as InfoS but uses depth to determine which call frame to log.
 // InfoSDepth(0, "msg") is the same as InfoS("msg").
 func InfoSDepth(depth int, msg string, keysAndValues ...interface{}) {
@@ -1384,6 +2929,14 @@ func (v Verbose) ErrorS(err error, msg string, keysAndValues ...interface{}) {
 	}
 }
 
+// ErrorSDepth is equivalent to the global ErrorSDepth function, guarded by the value of v.
+// See the documentation of V for usage.
+func (v Verbose) ErrorSDepth(depth int, err error, msg string, keysAndValues ...interface{}) {
+	if v.enabled {
+		logging.errorS(err, v.logr, v.filter, depth, msg, keysAndValues...)
+	}
+}
+
 // Info logs to the INFO log.
 // Arguments are handled in the manner of fmt.Print; a newline is appended if missing.
 func Info(args ...interface{}) {
@@ -1420,6 +2973,33 @@ func InfoS(msg string, keysAndValues ...interface{}) {
 	logging.infoS(logging.logr, logging.filter, 0, msg, keysAndValues...)
 }
 
+// defaultDebugVerbosity is the verbosity of Debug and DebugS unless changed
+// with SetDebugVerbosity.
+const defaultDebugVerbosity = 4
+
+// SetDebugVerbosity changes the verbosity at which Debug and DebugS log.
+// The default is 4.
+func SetDebugVerbosity(level Level) {
+	logging.debugVerbosity.set(level)
+}
+
+// Debug logs to the INFO log like Info, but only if the debug verbosity set
+// with SetDebugVerbosity is enabled at the call site, as for V. There is no
+// separate debug severity.
+func Debug(args ...interface{}) {
+	if v := vDepth(0, logging.debugVerbosity.get()); v.enabled {
+		logging.printDepth(infoLog, v.logr, v.filter, 0, args...)
+	}
+}
+
+// DebugS is the structured variant of Debug, equivalent to
+// V(level).InfoS(msg, keysAndValues...) with the debug verbosity as level.
+func DebugS(msg string, keysAndValues ...interface{}) {
+	if v := vDepth(0, logging.debugVerbosity.get()); v.enabled {
+		logging.infoS(v.logr, v.filter, 0, msg, keysAndValues...)
+	}
+}
+
 // Warning logs to the WARNING and INFO logs.
 // Arguments are handled in the manner of fmt.Print; a newline is appended if missing.
 func Warning(args ...interface{}) {
@@ -1481,6 +3061,32 @@ func ErrorS(err error, msg string, keysAndValues ...interface{}) {
 	logging.errorS(err, logging.logr, logging.filter, 0, msg, keysAndValues...)
 }
 
+// LogS logs a structured entry with a severity that is only known at
+// runtime, for example when forwarding entries from another logging
+// library. The severity is one of "INFO", "WARNING", "ERROR" and "FATAL",
+// in any case. An entry with FATAL severity terminates the process like
+// Fatal. err may be nil; for INFO and WARNING it is logged with the "err"
+// key like for ERROR. LogS returns an error and logs nothing if the
+// severity is unknown.
+//
+// Basic example:
+// >> klog.LogS("warning", nil, "Disk almost full", "device", "/dev/sda")
+func LogS(severity string, err error, msg string, keysAndValues ...interface{}) error {
+	s, ok := severityByName(severity)
+	if !ok {
+		return fmt.Errorf("klog.LogS: unknown severity %q", severity)
+	}
+	if s == errorLog {
+		logging.errorS(err, logging.logr, logging.filter, 0, msg, keysAndValues...)
+		return nil
+	}
+	if err != nil {
+		keysAndValues = append([]interface{}{"err", err}, keysAndValues...)
+	}
+	logging.severityS(s, logging.logr, logging.filter, 0, msg, keysAndValues...)
+	return nil
+}
+
 // ErrorSDepth acts as ErrorS but uses depth to determine which call frame to log.
 // ErrorSDepth(0, "msg") is the same as ErrorS("msg").
 func ErrorSDepth(depth int, err error, msg string, keysAndValues ...interface{}) {
@@ -1564,6 +3170,9 @@ func SetLogFilter(filter LogFilter) {
 type ObjectRef struct {
 	Name      string `json:"name"`
 	Namespace string `json:"namespace,omitempty"`
+	// UID is only set by KObj for objects implementing KMetadataUID. It
+	// is not part of String.
+	UID string `json:"uid,omitempty"`
 }
 
 func (ref ObjectRef) String() string {
@@ -1573,6 +3182,14 @@ func (ref ObjectRef) String() string {
 	return ref.Name
 }
 
+// MarshalLog returns the reference including the UID for encoding by a
+// structured logging backend.
+func (ref ObjectRef) MarshalLog() interface{} {
+	// The type without methods prevents the backend from using String.
+	type objectRef ObjectRef
+	return objectRef(ref)
+}
+
 // KMetadata is a subset of the kubernetes k8s.io/apimachinery/pkg/apis/meta/v1.Object interface
 // this interface may expand in the future, but will always be a subset of the
 // kubernetes k8s.io/apimachinery/pkg/apis/meta/v1.Object interface
@@ -1581,7 +3198,17 @@ type KMetadata interface {
 	GetNamespace() string
 }
 
-// KObj returns ObjectRef from ObjectMeta
+// KMetadataUID is implemented by objects which also have a UID, like the
+// kubernetes k8s.io/apimachinery/pkg/apis/meta/v1.Object interface. The
+// UID type in that interface is a string type, so objects may need a small
+// wrapper to implement it.
+type KMetadataUID interface {
+	KMetadata
+	GetUID() string
+}
+
+// KObj returns ObjectRef from ObjectMeta. The UID is included if obj
+// implements KMetadataUID.
 func KObj(obj KMetadata) ObjectRef {
 	if obj == nil {
 		return ObjectRef{}
@@ -1590,10 +3217,26 @@ func KObj(obj KMetadata) ObjectRef {
 		return ObjectRef{}
 	}
 
-	return ObjectRef{
+	ref := ObjectRef{
 		Name:      obj.GetName(),
 		Namespace: obj.GetNamespace(),
 	}
+	if withUID, ok := obj.(KMetadataUID); ok {
+		ref.UID = withUID.GetUID()
+	}
+	return ref
+}
+
+// KObj is equivalent to the global KObj function, guarded by the value of v.
+// It returns an empty ObjectRef without calling any method of obj when v is
+// disabled, for hot code paths:
+//
+// >> klog.V(2).InfoS("Pod updated", "pod", klog.V(2).KObj(pod))
+func (v Verbose) KObj(obj KMetadata) ObjectRef {
+	if !v.enabled {
+		return ObjectRef{}
+	}
+	return KObj(obj)
 }
 
 // KRef returns ObjectRef from name and namespace
@@ -1620,3 +3263,422 @@ func KObjs(arg interface{}) []ObjectRef {
 	}
 	return objectRefs
 }
+
+// WatchEvent returns a value that renders a watch event compactly as its
+// type followed by a reference to the object, for example
+// "MODIFIED kube-system/kube-dns". The object reference is resolved with
+// KObj only when the value is logged. Objects that do not implement
+// KMetadata, including nil, are rendered as the event type alone.
+func WatchEvent(eventType string, obj interface{}) fmt.Stringer {
+	return watchEvent{eventType: eventType, obj: obj}
+}
+
+// watchEvent is the value returned by WatchEvent.
+type watchEvent struct {
+	eventType string
+	obj       interface{}
+}
+
+func (e watchEvent) ref() ObjectRef {
+	if obj, ok := e.obj.(KMetadata); ok {
+		return KObj(obj)
+	}
+	return ObjectRef{}
+}
+
+// String returns the event type and the object reference.
+func (e watchEvent) String() string {
+	ref := e.ref()
+	if ref.Name == "" {
+		return e.eventType
+	}
+	return e.eventType + " " + ref.String()
+}
+
+// MarshalLog returns the event type and object reference for encoding by a
+// structured logging backend.
+func (e watchEvent) MarshalLog() interface{} {
+	return struct {
+		Type   string    `json:"type"`
+		Object ObjectRef `json:"object"`
+	}{
+		Type:   e.eventType,
+		Object: e.ref(),
+	}
+}
+
+// AsJSON returns a value that defers the JSON encoding of the object built by
+// fn until the value is actually logged. This avoids the cost of building and
+// encoding expensive objects when the log line is disabled:
+//
+//	klog.V(10).InfoS("Pod spec", "spec", klog.AsJSON(func() interface{} { return buildSpec() }))
+//
+// The text output contains the JSON encoding as a quoted string. Structured
+// logging backends which support MarshalLog receive the object itself.
+func AsJSON(fn func() interface{}) fmt.Stringer {
+	return lazyJSON(fn)
+}
+
+// lazyJSON is the value returned by AsJSON.
+type lazyJSON func() interface{}
+
+// String returns the JSON encoding of the object.
+func (l lazyJSON) String() string {
+	data, err := json.Marshal(l())
+	if err != nil {
+		return fmt.Sprintf("<JSON encoding error: %v>", err)
+	}
+	return string(data)
+}
+
+// MarshalLog returns the object for encoding by a structured logging backend.
+func (l lazyJSON) MarshalLog() interface{} {
+	return l()
+}
+
+// Redacted returns a value that is always rendered as "[REDACTED]", in the
+// text output as well as by structured logging backends and JSON encoding,
+// also when it is nested inside another value. It marks sensitive values
+// explicitly at the call site, independently of the key:
+//
+//	klog.InfoS("User logged in", "user", name, "token", klog.Redacted(token))
+//
+// The value itself is not stored.
+func Redacted(value interface{}) fmt.Stringer {
+	return redacted{}
+}
+
+// redacted is the value returned by Redacted.
+type redacted struct{}
+
+const redactedText = "[REDACTED]"
+
+func (redacted) String() string {
+	return redactedText
+}
+
+// GoString is used for %#v.
+func (redacted) GoString() string {
+	return redactedText
+}
+
+// MarshalLog returns the placeholder for encoding by a structured logging
+// backend.
+func (redacted) MarshalLog() interface{} {
+	return redactedText
+}
+
+// MarshalJSON returns the placeholder as JSON string.
+func (redacted) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + redactedText + `"`), nil
+}
+
+// KErrs returns a value that renders a slice of errors, for example the
+// errors collected by a multierror, as "[err1; err2]". The error messages are
+// only retrieved when the value is logged. Nil errors are rendered as
+// "<nil>".
+//
+// Basic example:
+// >> klog.ErrorS(nil, "Sync failed", "errs", klog.KErrs(errs))
+// output:
+// >> E1025 00:15:15.525108       1 controller.go:42] "Sync failed" errs="[timeout; connection refused]"
+func KErrs(errs []error) fmt.Stringer {
+	return kErrs(errs)
+}
+
+// kErrs is the value returned by KErrs.
+type kErrs []error
+
+// String returns the error messages separated by semicolons.
+func (e kErrs) String() string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, err := range e {
+		if i > 0 {
+			b.WriteString("; ")
+		}
+		b.WriteString(errorString(err))
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// MarshalLog returns the error messages for encoding by a structured logging
+// backend.
+func (e kErrs) MarshalLog() interface{} {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, errorString(err))
+	}
+	return msgs
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return "<nil>"
+	}
+	return err.Error()
+}
+
+// KVList returns a value which, when passed in the position of a key to a
+// structured logging call like InfoS, is replaced by the key/value pairs
+// stored in list. list must be a slice of structs with a string field Key
+// and a field Value, for example []struct{Key string; Value interface{}}.
+// Other elements are skipped. The slice is only flattened when the log
+// line is emitted. Duplicate keys are handled like any other duplicate key.
+//
+// Basic example:
+// >> klog.InfoS("Pod status updated", klog.KVList(fields), "status", "ready")
+func KVList(list interface{}) interface{} {
+	return kvList{list}
+}
+
+// kvList is the value returned by KVList.
+type kvList struct {
+	list interface{}
+}
+
+// flatten returns the key/value pairs stored in the list.
+func (l kvList) flatten() []interface{} {
+	s := reflect.ValueOf(l.list)
+	if s.Kind() != reflect.Slice {
+		return nil
+	}
+	keysAndValues := make([]interface{}, 0, 2*s.Len())
+	for i := 0; i < s.Len(); i++ {
+		item := reflect.Indirect(s.Index(i))
+		if item.Kind() != reflect.Struct {
+			continue
+		}
+		key := item.FieldByName("Key")
+		value := item.FieldByName("Value")
+		if !key.IsValid() || key.Kind() != reflect.String || !value.IsValid() || !value.CanInterface() {
+			continue
+		}
+		keysAndValues = append(keysAndValues, key.String(), value.Interface())
+	}
+	return keysAndValues
+}
+
+// WithCode returns a value which, when passed in the position of a key to a
+// structured logging call like ErrorS, is replaced by a "code" key with the
+// given value. This gives entries a stable code for alerting in addition to
+// the human-readable message, in the text output as well as for logr
+// backends, which receive it as a normal key/value pair.
+//
+// Basic example:
+// >> klog.ErrorS(err, "Failed to update pod status", klog.WithCode("E1234"), "pod", klog.KObj(pod))
+// output:
+// >> E1025 00:15:15.525108       1 controller_utils.go:114] "Failed to update pod status" err="timeout" code="E1234" pod="kube-system/kubedns"
+func WithCode(code string) interface{} {
+	return errorCode(code)
+}
+
+// errorCode is the value returned by WithCode.
+type errorCode string
+
+// flatten returns the "code" key/value pair.
+func (c errorCode) flatten() []interface{} {
+	return []interface{}{"code", string(c)}
+}
+
+// Group returns a value which, when passed in the position of a key to a
+// structured logging call like InfoS, is replaced by the given key/value
+// pairs with keys prefixed by name and a dot in the text output. A logger
+// installed with SetLogger receives a single pair instead, with name as key
+// and a map of the pairs as value. Groups can be nested.
+//
+// Basic example:
+// >> klog.InfoS("Request done", klog.Group("http", "method", "GET", "status", 200))
+// output:
+// >> I1025 00:15:15.525108       1 server.go:74] "Request done" http.method="GET" http.status=200
+func Group(name string, keysAndValues ...interface{}) interface{} {
+	return group{name: name, keysAndValues: keysAndValues}
+}
+
+// group is the value returned by Group.
+type group struct {
+	name          string
+	keysAndValues []interface{}
+}
+
+// flatten returns the pairs of the group, including those of nested groups,
+// with prefixed keys.
+func (g group) flatten() []interface{} {
+	pairs := expandKVLists(g.keysAndValues)
+	flattened := make([]interface{}, 0, len(pairs)+len(pairs)%2)
+	for i := 0; i < len(pairs); i += 2 {
+		var v interface{} = missingValue
+		if i+1 < len(pairs) {
+			v = pairs[i+1]
+		}
+		flattened = append(flattened, fmt.Sprintf("%s.%v", g.name, pairs[i]), v)
+	}
+	return flattened
+}
+
+// nestGroups replaces each Group found in a key position with its name and
+// a map of its pairs, for loggers installed with SetLogger. The input slice
+// is returned unmodified if there is no group.
+func nestGroups(keysAndValues []interface{}) []interface{} {
+	found := false
+	for i := 0; i < len(keysAndValues); i += kvStep(keysAndValues[i]) {
+		if _, ok := keysAndValues[i].(group); ok {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return keysAndValues
+	}
+	nested := make([]interface{}, 0, len(keysAndValues)+1)
+	for i := 0; i < len(keysAndValues); {
+		if g, ok := keysAndValues[i].(group); ok {
+			pairs := expandKVLists(nestGroups(g.keysAndValues))
+			values := make(map[string]interface{}, len(pairs)/2)
+			for j := 0; j < len(pairs); j += 2 {
+				var v interface{} = missingValue
+				if j+1 < len(pairs) {
+					v = pairs[j+1]
+				}
+				values[fmt.Sprint(pairs[j])] = v
+			}
+			nested = append(nested, g.name, values)
+			i++
+			continue
+		}
+		// Other expanders are left to expandKVLists.
+		if _, ok := keysAndValues[i].(kvExpander); ok {
+			nested = append(nested, keysAndValues[i])
+			i++
+			continue
+		}
+		nested = append(nested, keysAndValues[i])
+		if i+1 < len(keysAndValues) {
+			nested = append(nested, keysAndValues[i+1])
+		}
+		i += 2
+	}
+	return nested
+}
+
+// kvExpander is implemented by the values which expandKVLists replaces.
+type kvExpander interface {
+	flatten() []interface{}
+}
+
+// kvStep returns the number of slots taken by the entry which starts with
+// key in a key/value list: one for a kvExpander, two for a key/value pair.
+func kvStep(key interface{}) int {
+	if _, ok := key.(kvExpander); ok {
+		return 1
+	}
+	return 2
+}
+
+// expandKVLists replaces each KVList, WithCode or Group found in a key
+// position with the key/value pairs it holds. The input slice is returned
+// unmodified if there is nothing to expand.
+func expandKVLists(keysAndValues []interface{}) []interface{} {
+	found := false
+	for i := 0; i < len(keysAndValues); i += kvStep(keysAndValues[i]) {
+		if _, ok := keysAndValues[i].(kvExpander); ok {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return keysAndValues
+	}
+	expanded := make([]interface{}, 0, len(keysAndValues))
+	for i := 0; i < len(keysAndValues); {
+		if l, ok := keysAndValues[i].(kvExpander); ok {
+			expanded = append(expanded, l.flatten()...)
+			i++
+			continue
+		}
+		expanded = append(expanded, keysAndValues[i])
+		if i+1 < len(keysAndValues) {
+			expanded = append(expanded, keysAndValues[i+1])
+		}
+		i += 2
+	}
+	return expanded
+}
+
+// errorCauses is the value of the errCauses key. It is a plain string slice
+// for logr implementations and gets rendered with quoted entries in the
+// text format.
+type errorCauses []string
+
+// Format implements fmt.Formatter.
+func (c errorCauses) Format(f fmt.State, verb rune) {
+	fmt.Fprintf(f, "%q", []string(c))
+}
+
+// unwrapCauses returns the messages of the errors wrapped by err, as found
+// by repeatedly calling errors.Unwrap, outermost first. A message identical
+// to the one before it is skipped.
+func unwrapCauses(err error) errorCauses {
+	if err == nil {
+		return nil
+	}
+	var causes errorCauses
+	last := err.Error()
+	for cause := errors.Unwrap(err); cause != nil; cause = errors.Unwrap(cause) {
+		if msg := cause.Error(); msg != last {
+			causes = append(causes, msg)
+			last = msg
+		}
+	}
+	return causes
+}
+
+// ErrorFields can be implemented by errors which carry additional
+// information as key/value pairs. ErrorS and the other structured error
+// functions add these pairs to the entry after the ones passed by the
+// caller, skipping keys which the caller already passed. The first error
+// implementing the interface in the chain of wrapped errors is used.
+//
+// Basic example:
+// >> func (e *QuotaError) LogFields() []interface{} {
+// >>	return []interface{}{"quota", e.Quota, "used", e.Used}
+// >> }
+type ErrorFields interface {
+	LogFields() []interface{}
+}
+
+// mergeErrorFields appends the key/value pairs of err, if it implements
+// ErrorFields, to keysAndValues.
+func mergeErrorFields(err error, keysAndValues []interface{}) []interface{} {
+	var withFields ErrorFields
+	if err == nil || !errors.As(err, &withFields) {
+		return keysAndValues
+	}
+	fields := withFields.LogFields()
+	if len(fields) == 0 {
+		return keysAndValues
+	}
+	merged := keysAndValues[:len(keysAndValues):len(keysAndValues)]
+	for i := 0; i < len(fields); i += 2 {
+		if key, ok := fields[i].(string); ok && hasKey(keysAndValues, key) {
+			continue
+		}
+		var v interface{} = missingValue
+		if i+1 < len(fields) {
+			v = fields[i+1]
+		}
+		merged = append(merged, fields[i], v)
+	}
+	return merged
+}
+
+// hasKey reports whether key is one of the keys in keysAndValues.
+func hasKey(keysAndValues []interface{}, key string) bool {
+	for i := 0; i < len(keysAndValues); i += 2 {
+		if k, ok := keysAndValues[i].(string); ok && k == key {
+			return true
+		}
+	}
+	return false
+}
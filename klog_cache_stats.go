@@ -0,0 +1,88 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package klog
+
+import (
+	"sync"
+	"time"
+)
+
+// CacheStats counts cache hits and misses and periodically logs the hit
+// ratio observed since the previous log entry. It is safe for concurrent use.
+//
+// Basic example:
+// >> stats := klog.NewCacheStats("objectCache")
+// >> stats.Hit()
+// >> stats.Miss()
+// >> stats.LogIfStale(time.Minute)
+// output:
+// >> I1025 00:15:15.525108       1 cache.go:42] "Cache statistics" cache="objectCache" hits=1 misses=1 ratio=0.5 window="1m0s"
+type CacheStats struct {
+	name string
+
+	mu      sync.Mutex
+	hits    uint64
+	misses  uint64
+	lastLog time.Time
+}
+
+// NewCacheStats returns a CacheStats for the cache with the given name.
+// The first window starts now.
+func NewCacheStats(name string) *CacheStats {
+	return &CacheStats{
+		name:    name,
+		lastLog: timeNow(),
+	}
+}
+
+// Hit records a cache hit.
+func (c *CacheStats) Hit() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hits++
+}
+
+// Miss records a cache miss.
+func (c *CacheStats) Miss() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.misses++
+}
+
+// LogIfStale logs the hits, misses and hit ratio collected in the current
+// window if at least interval has passed since the window started. The
+// counters are then reset and a new window begins. It reports whether
+// anything was logged.
+func (c *CacheStats) LogIfStale(interval time.Duration) bool {
+	c.mu.Lock()
+	now := timeNow()
+	window := now.Sub(c.lastLog)
+	if window < interval {
+		c.mu.Unlock()
+		return false
+	}
+	hits, misses := c.hits, c.misses
+	c.hits, c.misses = 0, 0
+	c.lastLog = now
+	c.mu.Unlock()
+
+	var ratio float64
+	if total := hits + misses; total > 0 {
+		ratio = float64(hits) / float64(total)
+	}
+	logging.infoS(logging.logr, logging.filter, 0, "Cache statistics",
+		"cache", c.name, "hits", hits, "misses", misses, "ratio", ratio, "window", window)
+	return true
+}
@@ -0,0 +1,79 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package klog
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCacheStats(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	defer func(previous func() time.Time) { timeNow = previous }(timeNow)
+	now := time.Date(2006, 1, 2, 15, 4, 5, 0, time.Local)
+	timeNow = func() time.Time { return now }
+
+	c := NewCacheStats("objectCache")
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i < 3 {
+				c.Miss()
+			} else {
+				c.Hit()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if c.LogIfStale(time.Minute) {
+		t.Fatal("expected no log entry before the interval has passed")
+	}
+	if contents(infoLog) != "" {
+		t.Fatalf("unexpected output: %q", contents(infoLog))
+	}
+
+	now = now.Add(time.Minute)
+	if !c.LogIfStale(time.Minute) {
+		t.Fatal("expected a log entry once the interval has passed")
+	}
+	want := `"Cache statistics" cache="objectCache" hits=7 misses=3 ratio=0.7 window="1m0s"`
+	if !contains(infoLog, want, t) {
+		t.Errorf("expected %q in output, got %q", want, contents(infoLog))
+	}
+
+	// The counters and the window start over after logging.
+	logging.file[infoLog] = &flushBuffer{}
+	now = now.Add(30 * time.Second)
+	if c.LogIfStale(time.Minute) {
+		t.Fatal("expected throttling within the new window")
+	}
+	now = now.Add(30 * time.Second)
+	if !c.LogIfStale(time.Minute) {
+		t.Fatal("expected a log entry at the end of the new window")
+	}
+	want = `"Cache statistics" cache="objectCache" hits=0 misses=0 ratio=0 window="1m0s"`
+	if !contains(infoLog, want, t) {
+		t.Errorf("expected %q in output, got %q", want, contents(infoLog))
+	}
+	if !strings.Contains(contents(infoLog), "klog_cache_stats_test.go:") {
+		t.Errorf("expected caller of LogIfStale in header, got %q", contents(infoLog))
+	}
+}
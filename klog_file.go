@@ -19,12 +19,18 @@
 package klog
 
 import (
+	"compress/gzip"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/user"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -116,6 +122,126 @@ func logName(tag string, t time.Time) (name, link string) {
 	return name, program + "." + tag
 }
 
+// expandLogFilePattern returns the file name for tag and start time t as
+// configured with SetLogFilePattern.
+func expandLogFilePattern(pattern, tag string, t time.Time) string {
+	return strings.NewReplacer(
+		"{severity}", tag,
+		"{pid}", strconv.Itoa(pid),
+		"{host}", host,
+		"{date}", fmt.Sprintf("%04d%02d%02d-%02d%02d%02d", t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second()),
+	).Replace(pattern)
+}
+
+// logNameSuffix matches the part of a log file name after the tag, as
+// produced by logName: the time stamp and the pid, optionally followed by
+// the extension added by compressLogFile.
+var logNameSuffix = regexp.MustCompile(`^\d{8}-\d{6}\.\d+(\.gz)?$`)
+
+// removeOldLogs deletes the oldest log files for tag in dir so that at most
+// maxCount remain, including current. Only files following the naming scheme
+// of logName for this program, host and user are considered. A file and its
+// compressed copy count as one log file. Files which are still being
+// compressed are kept, so there may temporarily be more than maxCount.
+// Errors are ignored.
+func removeOldLogs(dir, tag, current string, maxCount int) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	prefix := fmt.Sprintf("%s.%s.%s.log.%s.", program, host, getUserName(), tag)
+	var old []string
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == current || !entry.Mode().IsRegular() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if !logNameSuffix.MatchString(name[len(prefix):]) {
+			continue
+		}
+		name = strings.TrimSuffix(name, ".gz")
+		if name == current || seen[name] {
+			continue
+		}
+		seen[name] = true
+		old = append(old, name)
+	}
+	if len(old) < maxCount {
+		return
+	}
+	// The time stamp comes first after the common prefix, so sorting by name
+	// sorts by creation time.
+	sort.Strings(old)
+	for _, name := range old[:len(old)-maxCount+1] {
+		path := filepath.Join(dir, name)
+		if isCompressing(path) {
+			continue
+		}
+		os.Remove(path)         // ignore err
+		os.Remove(path + ".gz") // ignore err
+	}
+}
+
+// compressing contains the log files which are being compressed in the
+// background.
+var compressing = struct {
+	sync.Mutex
+	files map[string]bool
+}{files: map[string]bool{}}
+
+func isCompressing(path string) bool {
+	compressing.Lock()
+	defer compressing.Unlock()
+	return compressing.files[filepath.Clean(path)]
+}
+
+// startCompressLogFile runs compressLogFile in the background. Failures are
+// reported on stderr.
+func startCompressLogFile(name string) {
+	path := filepath.Clean(name)
+	compressing.Lock()
+	compressing.files[path] = true
+	compressing.Unlock()
+	go func() {
+		err := compressLogFile(path)
+		compressing.Lock()
+		delete(compressing.files, path)
+		compressing.Unlock()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "log: compressing %s failed: %v\n", path, err)
+		}
+	}()
+}
+
+// compressLogFile writes a gzip-compressed copy of the file with the given
+// name to name.gz and removes the original on success. On failure, the
+// original is kept and a partially written copy is removed.
+func compressLogFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name + ".gz") // ignore err
+		return err
+	}
+	return os.Remove(name)
+}
+
 var onceLogDirs sync.Once
 
 // create creates a new log file and returns the file and its filename, which
@@ -132,6 +258,14 @@ func create(tag string, t time.Time, startup bool) (f *os.File, filename string,
 		}
 		return nil, "", fmt.Errorf("log: unable to create log: %v", err)
 	}
+	if logging.logFilePattern != "" {
+		fname := expandLogFilePattern(logging.logFilePattern, tag, t)
+		f, err := openOrCreate(fname, startup)
+		if err != nil {
+			return nil, "", fmt.Errorf("log: cannot create log: %v", err)
+		}
+		return f, fname, nil
+	}
 	onceLogDirs.Do(createLogDirs)
 	if len(logDirs) == 0 {
 		return nil, "", errors.New("log: no log dirs")
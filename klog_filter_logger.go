@@ -0,0 +1,100 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package klog
+
+import (
+	"github.com/go-logr/logr"
+)
+
+// NewFilterLogger returns a logger which passes Info and Error calls on to
+// delegate only if predicate returns true for them, regardless of the
+// verbosity or whether it is an error. The predicate receives the message
+// and all key/value pairs, including those added with WithValues before the
+// ones of the call itself. It must not modify the slice.
+//
+// Basic example:
+// >> logger := klog.NewFilterLogger(klogr.New(), func(msg string, kv []interface{}) bool {
+// >>	for i := 0; i < len(kv); i += 2 {
+// >>		if kv[i] == "ssn" {
+// >>			return false
+// >>		}
+// >>	}
+// >>	return true
+// >> })
+func NewFilterLogger(delegate logr.Logger, predicate func(msg string, keysAndValues []interface{}) bool) logr.Logger {
+	return filterLogger{
+		// Skip the frame of the filter logger itself.
+		delegate:  logr.WithCallDepth(delegate, 1),
+		predicate: predicate,
+	}
+}
+
+// filterLogger implements NewFilterLogger. The values added with WithValues
+// are passed to the delegate with each call instead of WithValues, so that
+// the predicate sees them.
+type filterLogger struct {
+	delegate  logr.Logger
+	predicate func(msg string, keysAndValues []interface{}) bool
+	values    []interface{}
+}
+
+var _ logr.CallDepthLogger = filterLogger{}
+
+func (l filterLogger) Enabled() bool {
+	return l.delegate.Enabled()
+}
+
+// merge returns the values of l followed by keysAndValues.
+func (l filterLogger) merge(keysAndValues []interface{}) []interface{} {
+	if len(l.values) == 0 {
+		return keysAndValues
+	}
+	merged := make([]interface{}, 0, len(l.values)+len(keysAndValues))
+	return append(append(merged, l.values...), keysAndValues...)
+}
+
+func (l filterLogger) Info(msg string, keysAndValues ...interface{}) {
+	keysAndValues = l.merge(keysAndValues)
+	if l.predicate(msg, keysAndValues) {
+		l.delegate.Info(msg, keysAndValues...)
+	}
+}
+
+func (l filterLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	keysAndValues = l.merge(keysAndValues)
+	if l.predicate(msg, keysAndValues) {
+		l.delegate.Error(err, msg, keysAndValues...)
+	}
+}
+
+func (l filterLogger) V(level int) logr.Logger {
+	l.delegate = l.delegate.V(level)
+	return l
+}
+
+func (l filterLogger) WithName(name string) logr.Logger {
+	l.delegate = l.delegate.WithName(name)
+	return l
+}
+
+func (l filterLogger) WithValues(keysAndValues ...interface{}) logr.Logger {
+	l.values = l.merge(keysAndValues)
+	return l
+}
+
+func (l filterLogger) WithCallDepth(depth int) logr.Logger {
+	l.delegate = logr.WithCallDepth(l.delegate, depth)
+	return l
+}
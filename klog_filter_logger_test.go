@@ -0,0 +1,55 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package klog
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestFilterLogger(t *testing.T) {
+	recorder := newRecordingLogr(1)
+	withoutSSN := func(msg string, keysAndValues []interface{}) bool {
+		for i := 0; i < len(keysAndValues); i += 2 {
+			if keysAndValues[i] == "ssn" {
+				return false
+			}
+		}
+		return true
+	}
+	logger := NewFilterLogger(recorder, withoutSSN).WithName("filter")
+
+	logger.Info("kept", "pod", "kubedns")
+	logger.Info("dropped", "ssn", "123-45-6789")
+	logger.Error(errors.New("failed"), "dropped", "ssn", "123-45-6789")
+	logger.V(1).Info("kept verbose")
+
+	user := logger.WithValues("ssn", "123-45-6789")
+	user.Info("dropped because of values")
+	user.Error(errors.New("failed"), "dropped because of values")
+
+	withPod := logger.WithValues("pod", "kubedns").WithValues("ns", "kube-system")
+	withPod.Error(errors.New("failed"), "kept", "count", 1)
+
+	expected := []string{
+		"info /filter v=0 kept [pod kubedns]",
+		"info /filter v=1 kept verbose []",
+		"error /filter failed kept [pod kubedns ns kube-system count 1]",
+	}
+	if !reflect.DeepEqual(*recorder.calls, expected) {
+		t.Errorf("expected calls %q, got %q", expected, *recorder.calls)
+	}
+}
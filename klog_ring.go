@@ -0,0 +1,74 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package klog
+
+import (
+	"strings"
+	"sync"
+)
+
+// RingBufferSink keeps the most recent formatted log lines in memory, for
+// example to include them in a crash report. It is an io.Writer which can
+// be passed to SetOutputBySeverity, possibly combined with other writers
+// through io.MultiWriter. The INFO output receives the entries of all
+// severities. It is safe for concurrent use.
+//
+// Basic example:
+// >> ring := klog.NewRingBufferSink(100)
+// >> klog.SetOutputBySeverity("INFO", io.MultiWriter(os.Stderr, ring))
+// >> ...
+// >> for _, line := range ring.Dump() { ... }
+type RingBufferSink struct {
+	mu    sync.Mutex
+	lines []string
+	// next is the index in lines for the next line once lines is full.
+	next int
+}
+
+// NewRingBufferSink returns a sink which keeps the last n lines. n must be
+// positive.
+func NewRingBufferSink(n int) *RingBufferSink {
+	if n <= 0 {
+		panic("klog.NewRingBufferSink: n must be positive")
+	}
+	return &RingBufferSink{lines: make([]string, 0, n)}
+}
+
+// Write stores each line of p, without the trailing newline. klog writes
+// one log entry per call, so an entry with a multi-line message takes more
+// than one line in the buffer.
+func (r *RingBufferSink) Write(p []byte) (int, error) {
+	text := strings.TrimSuffix(string(p), "\n")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, line := range strings.Split(text, "\n") {
+		if len(r.lines) < cap(r.lines) {
+			r.lines = append(r.lines, line)
+			continue
+		}
+		r.lines[r.next] = line
+		r.next = (r.next + 1) % len(r.lines)
+	}
+	return len(p), nil
+}
+
+// Dump returns the stored lines, oldest first.
+func (r *RingBufferSink) Dump() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	dump := make([]string, 0, len(r.lines))
+	dump = append(dump, r.lines[r.next:]...)
+	return append(dump, r.lines[:r.next]...)
+}
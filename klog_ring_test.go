@@ -0,0 +1,75 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package klog
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRingBufferSink(t *testing.T) {
+	ring := NewRingBufferSink(3)
+	if dump := ring.Dump(); len(dump) != 0 {
+		t.Errorf("expected empty dump, got %q", dump)
+	}
+
+	fmt.Fprintln(ring, "1")
+	fmt.Fprintln(ring, "2")
+	if dump, expected := ring.Dump(), []string{"1", "2"}; !reflect.DeepEqual(dump, expected) {
+		t.Errorf("expected %q, got %q", expected, dump)
+	}
+
+	fmt.Fprintln(ring, "3")
+	fmt.Fprint(ring, "4\n5\n")
+	if dump, expected := ring.Dump(), []string{"3", "4", "5"}; !reflect.DeepEqual(dump, expected) {
+		t.Errorf("expected %q, got %q", expected, dump)
+	}
+	fmt.Fprintln(ring, "6")
+	if dump, expected := ring.Dump(), []string{"4", "5", "6"}; !reflect.DeepEqual(dump, expected) {
+		t.Errorf("expected %q, got %q", expected, dump)
+	}
+}
+
+func TestRingBufferSinkOutput(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+
+	ring := NewRingBufferSink(2)
+	// Entries of all severities are written to the INFO output.
+	SetOutputBySeverity("INFO", ring)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			Infof("concurrent %d", i)
+		}(i)
+	}
+	wg.Wait()
+	Info("second to last")
+	Error("last")
+
+	dump := ring.Dump()
+	if len(dump) != 2 {
+		t.Fatalf("expected 2 lines, got %q", dump)
+	}
+	if !strings.HasSuffix(dump[0], "] second to last") || !strings.HasSuffix(dump[1], "] last") {
+		t.Errorf("unexpected lines %q", dump)
+	}
+}
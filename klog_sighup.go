@@ -0,0 +1,54 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows && !js
+// +build !windows,!js
+
+package klog
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// HandleSIGHUP starts a goroutine which calls ReopenLogFiles each time the
+// process receives SIGHUP, the signal sent by logrotate after it moved the
+// log files. Errors are reported on stderr. The returned function stops the
+// handling; SIGHUP then terminates the process again unless other handlers
+// are installed.
+//
+// On Windows, HandleSIGHUP does nothing.
+func HandleSIGHUP() (stop func()) {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-signals:
+				if err := ReopenLogFiles(); err != nil {
+					fmt.Fprintf(os.Stderr, "klog: %v\n", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
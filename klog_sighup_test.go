@@ -0,0 +1,62 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows && !js
+// +build !windows,!js
+
+package klog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestHandleSIGHUP(t *testing.T) {
+	setFlags()
+	SetLogger(nil)
+	dir, err := ioutil.TempDir("", "test_klog_HandleSIGHUP")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(previous string) { logging.logFile = previous }(logging.logFile)
+	logging.logFile = filepath.Join(dir, "test.log")
+	defer logging.swap(logging.swap([numSeverity]flushSyncWriter{}))
+
+	stop := HandleSIGHUP()
+	defer stop()
+
+	Info("before rotation")
+	if err := os.Rename(logging.logFile, logging.logFile+".1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The file is created again by the signal handler.
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, err := os.Stat(logging.logFile); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("log file was not reopened after SIGHUP")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
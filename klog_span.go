@@ -0,0 +1,131 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package klog
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Span is a lightweight, log-based representation of an operation. It logs
+// one line when it starts and one when it ends, both carrying the same span
+// ID, and records the elapsed time on completion.
+//
+// Basic example:
+// >> span := klog.StartSpan(ctx, "reconcile")
+// >> defer span.End()
+// output:
+// >> I1025 00:15:15.525108       1 controller.go:42] "Span started" span="reconcile" spanID="1"
+// >> I1025 00:15:15.625108       1 controller.go:42] "Span ended" span="reconcile" spanID="1" duration="100ms"
+type Span struct {
+	name     string
+	id       string
+	parentID string
+	start    time.Time
+	ctx      context.Context
+
+	mu    sync.Mutex
+	err   error
+	ended bool
+}
+
+// spanKey is the context key under which the active Span is stored.
+type spanKey struct{}
+
+// lastSpanID is incremented atomically for each new span.
+var lastSpanID uint64
+
+// StartSpan logs the start of the named operation and returns a Span for it.
+// If ctx already carries a span, its ID is logged as the parent span ID.
+// Use Context to propagate the new span to child operations.
+func StartSpan(ctx context.Context, name string) *Span {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	s := &Span{
+		name:  name,
+		id:    strconv.FormatUint(atomic.AddUint64(&lastSpanID, 1), 10),
+		start: timeNow(),
+	}
+	if parent := SpanFromContext(ctx); parent != nil {
+		s.parentID = parent.id
+	}
+	s.ctx = context.WithValue(ctx, spanKey{}, s)
+	logging.infoS(logging.logr, logging.filter, 0, "Span started", s.keysAndValues()...)
+	return s
+}
+
+// SpanFromContext returns the span stored in ctx by StartSpan, or nil if
+// there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Context returns a context derived from the one passed to StartSpan which
+// carries the span, so that child spans and logs can refer to it.
+func (s *Span) Context() context.Context {
+	return s.ctx
+}
+
+// ID returns the span ID. It returns an empty string for a nil span, which
+// allows logging SpanFromContext(ctx).ID() unconditionally.
+func (s *Span) ID() string {
+	if s == nil {
+		return ""
+	}
+	return s.id
+}
+
+// SetError records an error that End will report. The last error set wins.
+func (s *Span) SetError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// End logs the completion of the span together with its duration. If an
+// error was recorded with SetError, the line is logged as an error. Only the
+// first call to End logs anything.
+func (s *Span) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	err := s.err
+	s.mu.Unlock()
+
+	keysAndValues := append(s.keysAndValues(), "duration", timeNow().Sub(s.start))
+	if err != nil {
+		logging.errorS(err, logging.logr, logging.filter, 0, "Span ended", keysAndValues...)
+		return
+	}
+	logging.infoS(logging.logr, logging.filter, 0, "Span ended", keysAndValues...)
+}
+
+func (s *Span) keysAndValues() []interface{} {
+	if s.parentID != "" {
+		return []interface{}{"span", s.name, "spanID", s.id, "parentSpanID", s.parentID}
+	}
+	return []interface{}{"span", s.name, "spanID", s.id}
+}
@@ -0,0 +1,92 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package klog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSpan(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	defer func(previous func() time.Time) { timeNow = previous }(timeNow)
+	now := time.Date(2006, 1, 2, 15, 4, 5, 0, time.Local)
+	timeNow = func() time.Time { return now }
+
+	span := StartSpan(context.Background(), "reconcile")
+	now = now.Add(250 * time.Millisecond)
+	span.End()
+	span.End() // No second entry.
+
+	lines := strings.Split(strings.TrimSuffix(contents(infoLog), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d: %q", len(lines), contents(infoLog))
+	}
+	idKV := fmt.Sprintf("spanID=%q", span.ID())
+	if want := `"Span started" span="reconcile" ` + idKV; !strings.HasSuffix(lines[0], want) {
+		t.Errorf("expected start line to end with %q, got %q", want, lines[0])
+	}
+	if want := `"Span ended" span="reconcile" ` + idKV + ` duration="250ms"`; !strings.HasSuffix(lines[1], want) {
+		t.Errorf("expected end line to end with %q, got %q", want, lines[1])
+	}
+}
+
+func TestSpanError(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	defer func(previous func() time.Time) { timeNow = previous }(timeNow)
+	now := time.Date(2006, 1, 2, 15, 4, 5, 0, time.Local)
+	timeNow = func() time.Time { return now }
+
+	span := StartSpan(context.Background(), "sync")
+	span.SetError(errors.New("conflict"))
+	now = now.Add(time.Second)
+	span.End()
+
+	want := fmt.Sprintf(`"Span ended" err="conflict" span="sync" spanID=%q duration="1s"`, span.ID())
+	if !contains(errorLog, want, t) {
+		t.Errorf("expected %q in error log, got %q", want, contents(errorLog))
+	}
+}
+
+func TestSpanContext(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+
+	if SpanFromContext(context.Background()) != nil {
+		t.Fatal("expected no span in an empty context")
+	}
+	if id := SpanFromContext(context.Background()).ID(); id != "" {
+		t.Fatalf("expected empty ID for a nil span, got %q", id)
+	}
+
+	parent := StartSpan(context.Background(), "parent")
+	if SpanFromContext(parent.Context()) != parent {
+		t.Fatal("expected the span to be stored in its context")
+	}
+	child := StartSpan(parent.Context(), "child")
+	if child.ID() == parent.ID() {
+		t.Fatalf("expected distinct span IDs, got %q twice", child.ID())
+	}
+	want := fmt.Sprintf(`"Span started" span="child" spanID=%q parentSpanID=%q`, child.ID(), parent.ID())
+	if !contains(infoLog, want, t) {
+		t.Errorf("expected %q in output, got %q", want, contents(infoLog))
+	}
+}
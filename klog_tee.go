@@ -0,0 +1,87 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package klog
+
+import (
+	"github.com/go-logr/logr"
+)
+
+// NewTeeLogger returns a logger which forwards each call to all of the
+// given loggers, for example to klogr and to a structured collector. It is
+// enabled if any of them is enabled. V, WithName, WithValues and
+// WithCallDepth are applied to each logger and return a new tee logger.
+//
+// Basic example:
+// >> logger := klog.NewTeeLogger(klogr.New(), collector)
+// >> logger.Info("Pod status updated", "pod", "kubedns")
+func NewTeeLogger(loggers ...logr.Logger) logr.Logger {
+	tee := make(teeLogger, 0, len(loggers))
+	for _, logger := range loggers {
+		// Skip the frame of the tee logger itself.
+		tee = append(tee, logr.WithCallDepth(logger, 1))
+	}
+	return tee
+}
+
+// teeLogger implements NewTeeLogger.
+type teeLogger []logr.Logger
+
+var _ logr.CallDepthLogger = teeLogger{}
+
+func (t teeLogger) Enabled() bool {
+	for _, logger := range t {
+		if logger.Enabled() {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeLogger) Info(msg string, keysAndValues ...interface{}) {
+	for _, logger := range t {
+		logger.Info(msg, keysAndValues...)
+	}
+}
+
+func (t teeLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	for _, logger := range t {
+		logger.Error(err, msg, keysAndValues...)
+	}
+}
+
+// each returns a new tee logger with the result of fn for each logger.
+func (t teeLogger) each(fn func(logger logr.Logger) logr.Logger) teeLogger {
+	tee := make(teeLogger, 0, len(t))
+	for _, logger := range t {
+		tee = append(tee, fn(logger))
+	}
+	return tee
+}
+
+func (t teeLogger) V(level int) logr.Logger {
+	return t.each(func(logger logr.Logger) logr.Logger { return logger.V(level) })
+}
+
+func (t teeLogger) WithName(name string) logr.Logger {
+	return t.each(func(logger logr.Logger) logr.Logger { return logger.WithName(name) })
+}
+
+func (t teeLogger) WithValues(keysAndValues ...interface{}) logr.Logger {
+	return t.each(func(logger logr.Logger) logr.Logger { return logger.WithValues(keysAndValues...) })
+}
+
+func (t teeLogger) WithCallDepth(depth int) logr.Logger {
+	return t.each(func(logger logr.Logger) logr.Logger { return logr.WithCallDepth(logger, depth) })
+}
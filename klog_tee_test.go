@@ -0,0 +1,103 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package klog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+// recordingLogr records each call as a string, including the name, level
+// and values of the logger.
+type recordingLogr struct {
+	calls    *[]string
+	name     string
+	level    int
+	maxLevel int
+	values   []interface{}
+}
+
+func newRecordingLogr(maxLevel int) recordingLogr {
+	return recordingLogr{calls: &[]string{}, maxLevel: maxLevel}
+}
+
+func (l recordingLogr) Enabled() bool { return l.level <= l.maxLevel }
+
+func (l recordingLogr) Info(msg string, keysAndValues ...interface{}) {
+	*l.calls = append(*l.calls, fmt.Sprintf("info %s v=%d %s %v", l.name, l.level, msg, append(l.values, keysAndValues...)))
+}
+
+func (l recordingLogr) Error(err error, msg string, keysAndValues ...interface{}) {
+	*l.calls = append(*l.calls, fmt.Sprintf("error %s %v %s %v", l.name, err, msg, append(l.values, keysAndValues...)))
+}
+
+func (l recordingLogr) V(level int) logr.Logger {
+	l.level += level
+	return l
+}
+
+func (l recordingLogr) WithName(name string) logr.Logger {
+	l.name += "/" + name
+	return l
+}
+
+func (l recordingLogr) WithValues(keysAndValues ...interface{}) logr.Logger {
+	l.values = append(l.values[:len(l.values):len(l.values)], keysAndValues...)
+	return l
+}
+
+func TestTeeLogger(t *testing.T) {
+	first, second := newRecordingLogr(1), newRecordingLogr(3)
+	logger := NewTeeLogger(first, second).WithName("tee").WithValues("pod", "kubedns")
+
+	logger.Info("info", "count", 1)
+	logger.Error(errors.New("failed"), "error")
+	logger.V(2).Info("verbose")
+
+	if !logger.V(2).Enabled() {
+		t.Error("expected V(2) to be enabled because of the second logger")
+	}
+	if logger.V(4).Enabled() {
+		t.Error("expected V(4) to be disabled")
+	}
+
+	expected := []string{
+		"info /tee v=0 info [pod kubedns count 1]",
+		"error /tee failed error [pod kubedns]",
+		"info /tee v=2 verbose [pod kubedns]",
+	}
+	for name, calls := range map[string][]string{"first": *first.calls, "second": *second.calls} {
+		if !reflect.DeepEqual(calls, expected) {
+			t.Errorf("%s logger: expected calls %q, got %q", name, expected, calls)
+		}
+	}
+}
+
+func TestTeeLoggerCallDepth(t *testing.T) {
+	var first, second bytes.Buffer
+	logger := NewTeeLogger(NewLoggerToWriter(&first), NewLoggerToWriter(&second))
+	logger.Info("hello")
+	for _, output := range []string{first.String(), second.String()} {
+		if !strings.Contains(output, " klog_tee_test.go:") {
+			t.Errorf("expected the caller of the tee logger in the header, got %q", output)
+		}
+	}
+}
@@ -18,6 +18,9 @@ package klog
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -31,6 +34,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -222,6 +226,68 @@ func TestHeaderWithDir(t *testing.T) {
 	}
 }
 
+func TestHeaderWithCallerFunc(t *testing.T) {
+	setFlags()
+	logging.callerFunc = true
+	defer func() { logging.callerFunc = false }()
+	defer logging.swap(logging.newBuffers())
+	defer func(previous func() time.Time) { timeNow = previous }(timeNow)
+	timeNow = func() time.Time {
+		return time.Date(2006, 1, 2, 15, 4, 5, .067890e9, time.Local)
+	}
+	pid = 1234
+	Info("test")
+	InfoS("structured")
+	func() {
+		InfoDepth(1, "depth")
+		Info("closure")
+	}()
+	re := regexp.MustCompile(`^I0102 15:04:05.067890    1234 klog_test.go:\d+ TestHeaderWithCallerFunc\] test
+I0102 15:04:05.067890    1234 klog_test.go:\d+ TestHeaderWithCallerFunc\] "structured"
+I0102 15:04:05.067890    1234 klog_test.go:\d+ TestHeaderWithCallerFunc\] depth
+I0102 15:04:05.067890    1234 klog_test.go:\d+ TestHeaderWithCallerFunc.func\d+\] closure
+$`)
+	if !re.MatchString(contents(infoLog)) {
+		t.Errorf("log format error: output does not match regex:\n\t%q\n", contents(infoLog))
+	}
+}
+
+func TestCallerPrefixTrim(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	defer SetCallerPrefixTrim("")
+	_, path, _, _ := runtime.Caller(0)
+	dir := filepath.Dir(path)
+	prefix := filepath.Dir(dir) + "/"
+	trimmed := filepath.Base(dir) + "/klog_test.go:"
+
+	SetCallerPrefixTrim(prefix)
+	Info("matching")
+	if !contains(infoLog, " "+trimmed, t) {
+		t.Errorf("expected %q in header, got %q", trimmed, contents(infoLog))
+	}
+
+	logging.newBuffers()
+	SetCallerPrefixTrim("/no/such/prefix/")
+	Info("not matching")
+	if !contains(infoLog, " klog_test.go:", t) || contains(infoLog, trimmed, t) {
+		t.Errorf("expected base name in header, got %q", contents(infoLog))
+	}
+
+	// Changing the prefix while logging must be safe, see "go test -race".
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			Info("concurrent")
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		SetCallerPrefixTrim(prefix[:i%len(prefix)])
+	}
+	<-done
+}
+
 // Test that an Error log goes to Warning and Info.
 // Even in the Info log, the source character will be E, so the data should
 // all be identical.
@@ -374,10 +440,11 @@ func TestSetOutputDataRace(t *testing.T) {
 	setFlags()
 	defer logging.swap(logging.newBuffers())
 	var wg sync.WaitGroup
+	var daemons []*flushDaemon
 	for i := 1; i <= 50; i++ {
-		go func() {
-			logging.flushDaemon()
-		}()
+		daemon := newFlushDaemon(logging.lockAndFlushAll)
+		daemon.run(time.Second)
+		daemons = append(daemons, daemon)
 	}
 	for i := 1; i <= 50; i++ {
 		wg.Add(1)
@@ -387,9 +454,9 @@ func TestSetOutputDataRace(t *testing.T) {
 		}()
 	}
 	for i := 1; i <= 50; i++ {
-		go func() {
-			logging.flushDaemon()
-		}()
+		daemon := newFlushDaemon(logging.lockAndFlushAll)
+		daemon.run(time.Second)
+		daemons = append(daemons, daemon)
 	}
 	for i := 1; i <= 50; i++ {
 		wg.Add(1)
@@ -399,11 +466,14 @@ func TestSetOutputDataRace(t *testing.T) {
 		}()
 	}
 	for i := 1; i <= 50; i++ {
-		go func() {
-			logging.flushDaemon()
-		}()
+		daemon := newFlushDaemon(logging.lockAndFlushAll)
+		daemon.run(time.Second)
+		daemons = append(daemons, daemon)
 	}
 	wg.Wait()
+	for _, d := range daemons {
+		d.stop()
+	}
 }
 
 func TestLogToOutput(t *testing.T) {
@@ -446,7 +516,7 @@ func testVmoduleGlob(pat string, match bool, t *testing.T) {
 	defer logging.vmodule.Set("")
 	logging.vmodule.Set(pat)
 	if V(2).Enabled() != match {
-		t.Errorf("incorrect match for %q: got %t expected %t", pat, V(2), match)
+		t.Errorf("incorrect match for %q: got %t expected %t", pat, V(2).Enabled(), match)
 	}
 }
 
@@ -457,6 +527,95 @@ func TestVmoduleGlob(t *testing.T) {
 	}
 }
 
+// Since V calls vDepth, V is small enough to get inlined into the caller,
+// which must not attribute the call to klog.go.
+func TestVmoduleInlined(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	defer logging.vmodule.Set("")
+	logging.vmodule.Set("klog_test=2")
+	enabled := func() bool { return V(2).Enabled() }
+	for i := 0; i < 3; i++ {
+		if !enabled() {
+			t.Errorf("expected V(2) in a closure to be enabled by -vmodule, iteration %d", i)
+		}
+	}
+	logging.vmodule.Set("klog=2")
+	if enabled() {
+		t.Error("expected V(2) in a closure to be disabled when -vmodule only matches klog.go")
+	}
+}
+
+func TestVerboseLevel(t *testing.T) {
+	for vmodule, expected := range map[string]Level{
+		"":            1,
+		"klog_test=2": 2,
+		"klog_test=0": 1,
+		"*=3":         3,
+		"other=5":     1,
+	} {
+		t.Run(vmodule, func(t *testing.T) {
+			setFlags()
+			defer logging.swap(logging.newBuffers())
+			defer logging.verbosity.Set("0")
+			defer logging.vmodule.Set("")
+			logging.verbosity.Set("1")
+			logging.vmodule.Set(vmodule)
+			for n := Level(2); n < 5; n++ {
+				level, enabled := V(n).Level()
+				if level != expected {
+					t.Errorf("V(%d).Level() returned level %d, expected %d", n, level, expected)
+				}
+				if expected := V(n).Enabled(); enabled != expected {
+					t.Errorf("V(%d).Level() returned enabled %t, V(%d).Enabled() returned %t", n, enabled, n, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestVModuleRules(t *testing.T) {
+	defer logging.vmodule.Set("")
+	fs := flag.NewFlagSet("test", flag.PanicOnError)
+	InitFlags(fs)
+
+	if rules := VModuleRules(); len(rules) != 0 {
+		t.Errorf("expected no rules, got %v", rules)
+	}
+	if err := fs.Set("vmodule", "recordio=2,file=0,gfs*=3"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []VModuleRule{{Pattern: "recordio", Level: 2}, {Pattern: "gfs*", Level: 3}}
+	if rules := VModuleRules(); !reflect.DeepEqual(rules, expected) {
+		t.Errorf("expected %v, got %v", expected, rules)
+	}
+}
+
+func TestInfofFunc(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+	defer logging.verbosity.Set("0")
+	logging.verbosity.Set("2")
+
+	called := 0
+	fn := func() (string, []interface{}) {
+		called++
+		return "pods: %d", []interface{}{called}
+	}
+	V(3).InfofFunc(fn)
+	if called != 0 {
+		t.Error("expected the function not to be called for a disabled level")
+	}
+	V(2).InfofFunc(fn)
+	if called != 1 {
+		t.Errorf("expected the function to be called once, got %d calls", called)
+	}
+	if !contains(infoLog, " klog_test.go:", t) || !strings.HasSuffix(contents(infoLog), "] pods: 1\n") {
+		t.Errorf("unexpected output %q", contents(infoLog))
+	}
+}
+
 func TestRollover(t *testing.T) {
 	setFlags()
 	var err error
@@ -606,6 +765,45 @@ func TestLogBacktraceAt(t *testing.T) {
 	}
 }
 
+func TestPutBufferCapacity(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+	defer func(previous int) { MaxPooledBufferSize = previous }(MaxPooledBufferSize)
+
+	pooled := func() []*buffer {
+		logging.freeListMu.Lock()
+		defer logging.freeListMu.Unlock()
+		var buffers []*buffer
+		for b := logging.freeList; b != nil; b = b.next {
+			buffers = append(buffers, b)
+		}
+		return buffers
+	}
+
+	Info(strings.Repeat("x", 100*1024))
+	for _, b := range pooled() {
+		if b.Cap() > MaxPooledBufferSize {
+			t.Errorf("buffer with capacity %d was returned to the pool", b.Cap())
+		}
+	}
+
+	MaxPooledBufferSize = 1024 * 1024
+	Info(strings.Repeat("x", 100*1024))
+	found := false
+	for _, b := range pooled() {
+		if b.Cap() >= 100*1024 {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("expected the large buffer in the pool after raising the threshold")
+	}
+	logging.freeListMu.Lock()
+	logging.freeList = nil
+	logging.freeListMu.Unlock()
+}
+
 func BenchmarkHeader(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		buf, _, _ := logging.header(infoLog, 0)
@@ -641,6 +839,62 @@ func BenchmarkKObj(b *testing.B) {
 	result = r
 }
 
+func BenchmarkVerboseKObjDisabled(b *testing.B) {
+	setFlags()
+	a := kMetadataMock{name: "a", ns: "a"}
+	var r ObjectRef
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r = V(10).KObj(&a)
+	}
+	result = r
+}
+
+func BenchmarkVerboseKObjEnabled(b *testing.B) {
+	setFlags()
+	defer logging.verbosity.set(logging.verbosity.get())
+	logging.verbosity.set(10)
+	a := kMetadataMock{name: "a", ns: "a"}
+	var r ObjectRef
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r = V(10).KObj(&a)
+	}
+	result = r
+}
+
+func BenchmarkKErrsDisabled(b *testing.B) {
+	errs := []error{errors.New("timeout"), errors.New("connection refused")}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		V(10).InfoS("test", "errs", KErrs(errs))
+	}
+}
+
+func BenchmarkInfoS(b *testing.B) {
+	setFlags()
+	defer logging.swap(logging.swap([numSeverity]flushSyncWriter{
+		&redirectBuffer{w: ioutil.Discard},
+		&redirectBuffer{w: ioutil.Discard},
+		&redirectBuffer{w: ioutil.Discard},
+		&redirectBuffer{w: ioutil.Discard},
+	}))
+	SetLogger(nil)
+
+	b.Run("no pairs", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			InfoS("Pod status updated")
+		}
+	})
+	b.Run("two pairs", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			InfoS("Pod status updated", "pod", "kubedns", "status", "ready")
+		}
+	})
+}
+
 func BenchmarkLogs(b *testing.B) {
 	setFlags()
 	defer logging.swap(logging.newBuffers())
@@ -729,6 +983,87 @@ func TestInitFlags(t *testing.T) {
 	}
 }
 
+func TestInitFlagsWithPrefix(t *testing.T) {
+	setFlags()
+	defer logging.verbosity.Set("0")
+	defer func(previous bool) { logging.alsoToStderr = previous }(logging.alsoToStderr)
+	defer func(previous uint64) { logging.logFileMaxSizeMB = previous }(logging.logFileMaxSizeMB)
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	InitFlagsWithPrefix("klog.", fs)
+	if fs.Lookup("v") != nil {
+		t.Fatal("expected no unprefixed flags")
+	}
+	if err := fs.Parse([]string{"-klog.v=3", "-klog.alsologtostderr", "-klog.log_file_max_size=42"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if logging.verbosity.get() != 3 {
+		t.Errorf("expected verbosity 3, got %d", logging.verbosity.get())
+	}
+	if !logging.alsoToStderr {
+		t.Error("expected alsologtostderr to be set")
+	}
+	if logging.logFileMaxSizeMB != 42 {
+		t.Errorf("expected log_file_max_size 42, got %d", logging.logFileMaxSizeMB)
+	}
+	if err := fs.Set("klog.stderrthreshold", "bogus"); err == nil {
+		t.Error("expected an error for an invalid stderrthreshold")
+	}
+}
+
+func TestSetters(t *testing.T) {
+	setFlags()
+	defer logging.verbosity.Set("0")
+	defer logging.vmodule.Set("")
+	defer logging.stderrThreshold.Set("ERROR")
+	defer func(previous bool) { logging.alsoToStderr = previous }(logging.alsoToStderr)
+	defer func(previous uint64) { logging.logFileMaxSizeMB = previous }(logging.logFileMaxSizeMB)
+	defer func(previous string) { logging.logDir = previous }(logging.logDir)
+	fs := flag.NewFlagSet("test", flag.PanicOnError)
+	InitFlags(fs)
+
+	SetVerbosity(4)
+	if got := fs.Lookup("v").Value.String(); got != "4" {
+		t.Errorf("expected -v=4, got %q", got)
+	}
+	fs.Set("v", "2")
+	if !V(2).Enabled() || V(3).Enabled() {
+		t.Errorf("expected verbosity 2 after setting the flag, got %d", logging.verbosity.get())
+	}
+
+	if err := SetVModule("foo=3,bar*=1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := fs.Lookup("vmodule").Value.String(), "foo=3,bar*=1"; got != want {
+		t.Errorf("expected -vmodule=%s, got %q", want, got)
+	}
+	if err := SetVModule("foo"); err == nil {
+		t.Error("expected an error for an invalid vmodule spec")
+	}
+
+	if err := SetStderrThreshold("warning"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := fs.Lookup("stderrthreshold").Value.String(); got != "1" {
+		t.Errorf("expected -stderrthreshold=1, got %q", got)
+	}
+	if err := SetStderrThreshold("bogus"); err == nil {
+		t.Error("expected an error for an invalid severity")
+	}
+
+	SetAlsoLogToStderr(true)
+	if got := fs.Lookup("alsologtostderr").Value.String(); got != "true" {
+		t.Errorf("expected -alsologtostderr=true, got %q", got)
+	}
+	SetLogFileMaxSize(7)
+	if got := fs.Lookup("log_file_max_size").Value.String(); got != "7" {
+		t.Errorf("expected -log_file_max_size=7, got %q", got)
+	}
+	SetLogDir("/some/dir")
+	if got := fs.Lookup("log_dir").Value.String(); got != "/some/dir" {
+		t.Errorf("expected -log_dir=/some/dir, got %q", got)
+	}
+}
+
 func TestInfoObjectRef(t *testing.T) {
 	setFlags()
 	defer logging.swap(logging.newBuffers())
@@ -793,6 +1128,29 @@ func (m *ptrKMetadataMock) GetNamespace() string {
 	return m.ns
 }
 
+type uidKMetadataMock struct {
+	kMetadataMock
+	uid string
+}
+
+func (m uidKMetadataMock) GetUID() string {
+	return m.uid
+}
+
+func TestVerboseKObj(t *testing.T) {
+	setFlags()
+	defer logging.verbosity.set(logging.verbosity.get())
+	logging.verbosity.set(2)
+
+	a := kMetadataMock{name: "a", ns: "b"}
+	if ref := V(2).KObj(a); ref != (ObjectRef{Name: "a", Namespace: "b"}) {
+		t.Errorf("expected the reference for V(2), got %#v", ref)
+	}
+	if ref := V(3).KObj(a); ref != (ObjectRef{}) {
+		t.Errorf("expected an empty reference for V(3), got %#v", ref)
+	}
+}
+
 func TestKObj(t *testing.T) {
 	tests := []struct {
 		name string
@@ -834,6 +1192,15 @@ func TestKObj(t *testing.T) {
 				Name: "test-name",
 			},
 		},
+		{
+			name: "with uid",
+			obj:  uidKMetadataMock{kMetadataMock{"test-name", "test-ns"}, "1234-abcd"},
+			want: ObjectRef{
+				Name:      "test-name",
+				Namespace: "test-ns",
+				UID:       "1234-abcd",
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -845,6 +1212,34 @@ func TestKObj(t *testing.T) {
 	}
 }
 
+func TestObjectRefMarshalLog(t *testing.T) {
+	withUID := KObj(uidKMetadataMock{kMetadataMock{"test-name", "test-ns"}, "1234-abcd"})
+	withoutUID := KObj(kMetadataMock{"test-name", "test-ns"})
+
+	for _, ref := range []ObjectRef{withUID, withoutUID} {
+		if got := ref.String(); got != "test-ns/test-name" {
+			t.Errorf("expected String to ignore the UID, got %q", got)
+		}
+	}
+
+	for expected, ref := range map[string]ObjectRef{
+		`{"name":"test-name","namespace":"test-ns","uid":"1234-abcd"}`: withUID,
+		`{"name":"test-name","namespace":"test-ns"}`:                   withoutUID,
+	} {
+		marshaled := ref.MarshalLog()
+		if _, ok := marshaled.(fmt.Stringer); ok {
+			t.Errorf("expected MarshalLog result without String method for %v", ref)
+		}
+		data, err := json.Marshal(marshaled)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(data) != expected {
+			t.Errorf("expected %s, got %s", expected, data)
+		}
+	}
+}
+
 func TestKRef(t *testing.T) {
 	tests := []struct {
 		testname  string
@@ -1724,19 +2119,34 @@ func checkLogrEntryCorrectCaller(t *testing.T, wantFile string, wantLine int, en
 
 // existedFlag contains all existed flag, without KlogPrefix
 var existedFlag = map[string]struct{}{
-	"log_dir":           {},
-	"add_dir_header":    {},
-	"alsologtostderr":   {},
-	"log_backtrace_at":  {},
-	"log_file":          {},
-	"log_file_max_size": {},
-	"logtostderr":       {},
-	"one_output":        {},
-	"skip_headers":      {},
-	"skip_log_headers":  {},
-	"stderrthreshold":   {},
-	"v":                 {},
-	"vmodule":           {},
+	"log_dir":             {},
+	"add_dir_header":      {},
+	"alsologtostderr":     {},
+	"log_backtrace_at":    {},
+	"log_caller_func":     {},
+	"log_dedup":           {},
+	"log_delta":           {},
+	"log_error_causes":    {},
+	"log_fatal_stacks":    {},
+	"log_file":            {},
+	"log_file_compress":   {},
+	"log_file_max_age":    {},
+	"log_file_max_count":  {},
+	"log_file_max_size":   {},
+	"log_file_threshold":  {},
+	"log_flush_frequency": {},
+	"log_host_pid":        {},
+	"log_logfmt":          {},
+	"log_monotonic":       {},
+	"log_sequence":        {},
+	"log_uptime":          {},
+	"logtostderr":         {},
+	"one_output":          {},
+	"skip_headers":        {},
+	"skip_log_headers":    {},
+	"stderrthreshold":     {},
+	"v":                   {},
+	"vmodule":             {},
 }
 
 // KlogPrefix define new flag prefix
@@ -1832,3 +2242,2433 @@ func TestKObjs(t *testing.T) {
 		})
 	}
 }
+
+func TestAsJSON(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+
+	called := false
+	obj := AsJSON(func() interface{} {
+		called = true
+		return map[string]interface{}{"replicas": 3, "name": "kube-dns"}
+	})
+
+	V(10).InfoS("test", "obj", obj)
+	if called {
+		t.Error("expected the object not to be built when the line is disabled")
+	}
+
+	InfoS("test", "obj", obj)
+	if !called {
+		t.Error("expected the object to be built when the line is enabled")
+	}
+	want := `"test" obj="{\"name\":\"kube-dns\",\"replicas\":3}"`
+	if !contains(infoLog, want, t) {
+		t.Errorf("expected %q in output, got %q", want, contents(infoLog))
+	}
+
+	marshaler, ok := obj.(interface{ MarshalLog() interface{} })
+	if !ok {
+		t.Fatal("expected AsJSON value to implement MarshalLog")
+	}
+	if got := marshaler.MarshalLog(); !reflect.DeepEqual(got, map[string]interface{}{"replicas": 3, "name": "kube-dns"}) {
+		t.Errorf("expected MarshalLog to return the raw object, got %#v", got)
+	}
+
+	broken := AsJSON(func() interface{} { return func() {} })
+	if got := broken.String(); !strings.HasPrefix(got, "<JSON encoding error: ") {
+		t.Errorf("expected encoding error placeholder, got %q", got)
+	}
+}
+
+func ExampleAsJSON() {
+	obj := AsJSON(func() interface{} {
+		return struct {
+			Name     string `json:"name"`
+			Replicas int    `json:"replicas"`
+		}{Name: "kube-dns", Replicas: 3}
+	})
+	fmt.Println(obj)
+	// Output: {"name":"kube-dns","replicas":3}
+}
+
+type countingError struct {
+	calls *int
+}
+
+func (e countingError) Error() string {
+	*e.calls++
+	return "counted"
+}
+
+func TestKErrs(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+
+	calls := 0
+	errs := []error{errors.New("timeout"), nil, countingError{&calls}}
+	V(10).InfoS("test", "errs", KErrs(errs))
+	if calls != 0 {
+		t.Error("expected the errors not to be formatted when the line is disabled")
+	}
+
+	InfoS("test", "errs", KErrs(errs))
+	want := `"test" errs="[timeout; <nil>; counted]"`
+	if !contains(infoLog, want, t) {
+		t.Errorf("expected %q in output, got %q", want, contents(infoLog))
+	}
+	if got := KErrs(nil).String(); got != "[]" {
+		t.Errorf("expected [] for no errors, got %q", got)
+	}
+
+	marshaler, ok := KErrs(errs).(interface{ MarshalLog() interface{} })
+	if !ok {
+		t.Fatal("expected KErrs value to implement MarshalLog")
+	}
+	if got, expected := marshaler.MarshalLog(), []string{"timeout", "<nil>", "counted"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected MarshalLog to return %q, got %#v", expected, got)
+	}
+}
+
+func TestSetSeverityTokens(t *testing.T) {
+	setFlags()
+	logging.oneOutput = true
+	defer func() { logging.oneOutput = false }()
+	defer logging.swap(logging.newBuffers())
+	defer SetSeverityTokens(nil)
+	SetLogger(nil)
+
+	if err := SetSeverityTokens(map[string]string{"info": "INF", "WARNING": "WRN", "ERROR": "ERR"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	Info("info")
+	Warning("warning")
+	Error("error")
+	for s, prefix := range map[severity]string{infoLog: "INF", warningLog: "WRN", errorLog: "ERR"} {
+		if got := contents(s); !regexp.MustCompile("^" + prefix + `\d{4} `).MatchString(got) {
+			t.Errorf("expected %s line to start with %q, got %q", severityName[s], prefix, got)
+		}
+	}
+
+	for name, tokens := range map[string]map[string]string{
+		"duplicate":        {"INFO": "E"},
+		"empty":            {"WARNING": ""},
+		"unknown severity": {"DEBUG": "D"},
+	} {
+		if err := SetSeverityTokens(tokens); err == nil {
+			t.Errorf("%s: expected an error for %v", name, tokens)
+		}
+	}
+
+	if err := SetSeverityTokens(nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logging.file[infoLog] = &flushBuffer{}
+	Info("info")
+	if got := contents(infoLog); !regexp.MustCompile(`^I\d{4} `).MatchString(got) {
+		t.Errorf("expected default token after reset, got %q", got)
+	}
+}
+
+func TestWatchEvent(t *testing.T) {
+	tests := []struct {
+		name      string
+		eventType string
+		obj       interface{}
+		want      string
+	}{
+		{
+			name:      "added",
+			eventType: "ADDED",
+			obj:       kMetadataMock{name: "kube-dns", ns: "kube-system"},
+			want:      "ADDED kube-system/kube-dns",
+		},
+		{
+			name:      "modified",
+			eventType: "MODIFIED",
+			obj:       &kMetadataMock{name: "kube-dns", ns: "kube-system"},
+			want:      "MODIFIED kube-system/kube-dns",
+		},
+		{
+			name:      "deleted",
+			eventType: "DELETED",
+			obj:       kMetadataMock{name: "node-1"},
+			want:      "DELETED node-1",
+		},
+		{
+			name:      "nil object",
+			eventType: "DELETED",
+			obj:       nil,
+			want:      "DELETED",
+		},
+		{
+			name:      "nil pointer",
+			eventType: "ADDED",
+			obj:       (*ptrKMetadataMock)(nil),
+			want:      "ADDED",
+		},
+		{
+			name:      "not KMetadata",
+			eventType: "BOOKMARK",
+			obj:       "some string",
+			want:      "BOOKMARK",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := WatchEvent(tt.eventType, tt.obj)
+			if got := event.String(); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+			b := &bytes.Buffer{}
+			kvListFormat(b, "event", event)
+			if want := fmt.Sprintf(" event=%q", tt.want); b.String() != want {
+				t.Errorf("expected %q, got %q", want, b.String())
+			}
+		})
+	}
+
+	marshaled := WatchEvent("ADDED", kMetadataMock{name: "a", ns: "b"}).(interface{ MarshalLog() interface{} }).MarshalLog()
+	data, err := json.Marshal(marshaled)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := `{"type":"ADDED","object":{"name":"a","namespace":"b"}}`; string(data) != want {
+		t.Errorf("expected %s, got %s", want, data)
+	}
+}
+
+// Test that Verbose.InfoSDepth and Verbose.ErrorSDepth log the caller of the wrapper.
+func TestVInfoSDepth(t *testing.T) {
+	setFlags()
+	logging.oneOutput = true
+	defer func() { logging.oneOutput = false }()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+	logging.verbosity.Set("2")
+	defer logging.verbosity.Set("0")
+
+	// The next three lines must stay together
+	_, _, wantLine, _ := runtime.Caller(0)
+	myVInfoS("info", "pod", "kubedns")
+	myVErrorS(errors.New("update failed"), "error", "pod", "kubedns")
+
+	if want := fmt.Sprintf("klog_test.go:%d] \"info\" pod=\"kubedns\"\n", wantLine+1); !strings.HasSuffix(contents(infoLog), want) {
+		t.Errorf("expected info line ending with %q, got %q", want, contents(infoLog))
+	}
+	if want := fmt.Sprintf("klog_test.go:%d] \"error\" err=\"update failed\" pod=\"kubedns\"\n", wantLine+2); !strings.HasSuffix(contents(errorLog), want) {
+		t.Errorf("expected error line ending with %q, got %q", want, contents(errorLog))
+	}
+
+	logging.newBuffers()
+	logging.verbosity.Set("1")
+	myVInfoS("info")
+	myVErrorS(errors.New("update failed"), "error")
+	if contents(infoLog) != "" {
+		t.Errorf("expected no output below the verbosity threshold, got %q", contents(infoLog))
+	}
+	if contents(errorLog) != "" {
+		t.Errorf("expected no error output below the verbosity threshold, got %q", contents(errorLog))
+	}
+}
+
+func TestVErrorS(t *testing.T) {
+	setFlags()
+	logging.oneOutput = true
+	defer func() { logging.oneOutput = false }()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+	defer logging.verbosity.Set("0")
+
+	logging.verbosity.Set("3")
+	V(4).ErrorS(errors.New("update failed"), "details", "pod", "kubedns")
+	if contents(errorLog) != "" {
+		t.Errorf("expected no output below the verbosity threshold, got %q", contents(errorLog))
+	}
+
+	logging.verbosity.Set("4")
+	V(4).ErrorS(errors.New("update failed"), "details", "pod", "kubedns")
+	want := `"details" err="update failed" pod="kubedns"`
+	if !contains(errorLog, want, t) {
+		t.Errorf("expected %q in error log, got %q", want, contents(errorLog))
+	}
+	if !strings.HasPrefix(contents(errorLog), "E") {
+		t.Errorf("expected an error entry, got %q", contents(errorLog))
+	}
+}
+
+func TestErrorCauses(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+	logging.errorCauses = true
+	defer func() { logging.errorCauses = false }()
+
+	root := errors.New("connection refused")
+	err := fmt.Errorf("update pod: %w", fmt.Errorf("%w", fmt.Errorf("dial: %w", root)))
+	ErrorS(err, "Sync failed", "pod", "kubedns")
+	want := `"Sync failed" err="update pod: dial: connection refused" pod="kubedns" errCauses=["dial: connection refused" "connection refused"]`
+	if !contains(errorLog, want, t) {
+		t.Errorf("expected %q in error log, got %q", want, contents(errorLog))
+	}
+
+	logging.newBuffers()
+	ErrorS(root, "Sync failed")
+	if strings.Contains(contents(errorLog), "errCauses") {
+		t.Errorf("unexpected errCauses for an error without causes: %q", contents(errorLog))
+	}
+
+	logging.newBuffers()
+	logging.errorCauses = false
+	ErrorS(err, "Sync failed")
+	if strings.Contains(contents(errorLog), "errCauses") {
+		t.Errorf("unexpected errCauses while disabled: %q", contents(errorLog))
+	}
+}
+
+// quotaError contributes two fields to the log entry.
+type quotaError struct {
+	quota, used int
+}
+
+func (e *quotaError) Error() string { return "quota exceeded" }
+
+func (e *quotaError) LogFields() []interface{} {
+	return []interface{}{"quota", e.quota, "used", e.used}
+}
+
+func TestErrorFields(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+
+	err := fmt.Errorf("create pod: %w", &quotaError{quota: 10, used: 12})
+	ErrorS(err, "Create failed", "pod", "kubedns")
+	want := `"Create failed" err="create pod: quota exceeded" pod="kubedns" quota=10 used=12`
+	if !contains(errorLog, want, t) {
+		t.Errorf("expected %q in error log, got %q", want, contents(errorLog))
+	}
+
+	logging.newBuffers()
+	ErrorS(err, "Create failed", "used", 11)
+	want = `"Create failed" err="create pod: quota exceeded" used=11 quota=10` + "\n"
+	if !strings.HasSuffix(contents(errorLog), want) {
+		t.Errorf("expected the value passed by the caller to win, got %q", contents(errorLog))
+	}
+
+	logger := new(testLogr)
+	SetLogger(logger)
+	defer SetLogger(nil)
+	ErrorS(err, "Create failed")
+	wantKVs := []interface{}{"quota", 10, "used", 12}
+	if len(logger.entries) != 1 || !reflect.DeepEqual(logger.entries[0].keysAndValues, wantKVs) {
+		t.Errorf("expected logr to receive %v, got %+v", wantKVs, logger.entries)
+	}
+}
+
+func TestStrictKVs(t *testing.T) {
+	setFlags()
+	logging.oneOutput = true
+	defer func() { logging.oneOutput = false }()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+
+	InfoS("no strict mode", "pod")
+	if contents(warningLog) != "" {
+		t.Fatalf("unexpected warning without strict mode: %q", contents(warningLog))
+	}
+
+	SetStrictKVs(true)
+	defer SetStrictKVs(false)
+	_, _, line, _ := runtime.Caller(0)
+	InfoS("odd", "pod")
+	ErrorS(errors.New("failed"), "odd error", "pod", "kubedns", "ns")
+	InfoS("even", "pod", "kubedns")
+
+	want := fmt.Sprintf(`klog_test.go:%d] "Odd number of arguments passed as key-value pairs for logging" msg="odd"`, line+1)
+	if !contains(warningLog, want, t) {
+		t.Errorf("expected %q in warning log, got %q", want, contents(warningLog))
+	}
+	want = fmt.Sprintf(`klog_test.go:%d] "Odd number of arguments passed as key-value pairs for logging" msg="odd error"`, line+2)
+	if !contains(warningLog, want, t) {
+		t.Errorf("expected %q in warning log, got %q", want, contents(warningLog))
+	}
+	if strings.Contains(contents(warningLog), `msg="even"`) {
+		t.Errorf("unexpected warning for an even list: %q", contents(warningLog))
+	}
+	if want := `"odd" pod="(MISSING)"`; !contains(infoLog, want, t) {
+		t.Errorf("expected unchanged entry %q, got %q", want, contents(infoLog))
+	}
+}
+
+// levelTestLogr is enabled up to a maximum verbosity. Loggers returned by V
+// share the entries with their parent.
+type levelTestLogr struct {
+	*testLogr
+	level, max int
+}
+
+func (l *levelTestLogr) Enabled() bool { return l.level <= l.max }
+func (l *levelTestLogr) V(level int) logr.Logger {
+	return &levelTestLogr{testLogr: l.testLogr, level: l.level + level, max: l.max}
+}
+
+func TestVContext(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+	defer logging.verbosity.Set("0")
+
+	logger := &levelTestLogr{testLogr: &testLogr{}, max: 4}
+	ctx := logr.NewContext(context.Background(), logger)
+
+	logging.verbosity.Set("1")
+	if V(3).Enabled() {
+		t.Fatal("expected V(3) to be disabled globally")
+	}
+	if !VContext(ctx, 3).Enabled() {
+		t.Error("expected VContext(3) to be enabled by the context logger")
+	}
+	if VContext(ctx, 5).Enabled() {
+		t.Error("expected VContext(5) to be disabled by the context logger")
+	}
+	VContext(ctx, 3).InfoS("enabled", "pod", "kubedns")
+	VContext(ctx, 5).InfoS("disabled")
+	if len(logger.entries) != 1 || logger.entries[0].msg != "enabled" {
+		t.Errorf("expected one entry in the context logger, got %+v", logger.entries)
+	}
+	if contents(infoLog) != "" {
+		t.Errorf("unexpected output without the context logger: %q", contents(infoLog))
+	}
+
+	logging.verbosity.Set("5")
+	if VContext(ctx, 5).Enabled() {
+		t.Error("expected the context logger to take precedence over the global verbosity")
+	}
+
+	// Without a logger in the context, the global verbosity applies.
+	if !VContext(context.Background(), 5).Enabled() || VContext(context.Background(), 6).Enabled() {
+		t.Error("expected VContext to behave like V without a context logger")
+	}
+	VContext(context.Background(), 5).InfoS("global")
+	if !contains(infoLog, `"global"`, t) {
+		t.Errorf("expected output from VContext without a context logger, got %q", contents(infoLog))
+	}
+}
+
+func TestFatalStacks(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+	defer func(previous func(error)) { logExitFunc = previous }(logExitFunc)
+	defer func(previous func(int)) { fatalExitFunc = previous }(fatalExitFunc)
+	var code int
+	fatalExitFunc = func(c int) { code = c }
+	defer logging.stderrThreshold.Set("ERROR")
+	logging.stderrThreshold.Set("4") // Keep the stacks off stderr.
+	defer logging.fatalStacks.Set("all")
+
+	// Make sure that there is more than one goroutine.
+	done := make(chan struct{})
+	defer close(done)
+	go func() { <-done }()
+
+	for mode, check := range map[string]func(goroutines int) bool{
+		"all":     func(goroutines int) bool { return goroutines > 1 },
+		"current": func(goroutines int) bool { return goroutines == 1 },
+		"none":    func(goroutines int) bool { return goroutines == 0 },
+	} {
+		t.Run(mode, func(t *testing.T) {
+			logging.newBuffers()
+			if err := logging.fatalStacks.Set(mode); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			code = 0
+			Fatal("fatal")
+			if code != 255 {
+				t.Errorf("expected exit code 255, got %d", code)
+			}
+			if !contains(fatalLog, "fatal", t) {
+				t.Errorf("expected the message in the fatal log, got %q", contents(fatalLog))
+			}
+			if goroutines := strings.Count(contents(fatalLog), "\ngoroutine "); !check(goroutines) {
+				t.Errorf("unexpected number of goroutine stacks %d in %q", goroutines, contents(fatalLog))
+			}
+		})
+	}
+
+	if err := logging.fatalStacks.Set("some"); err == nil {
+		t.Error("expected an error for an invalid mode")
+	}
+}
+
+func TestSetExitFunc(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+	defer func(previous func(error)) { logExitFunc = previous }(logExitFunc)
+	defer logging.stderrThreshold.Set("ERROR")
+	logging.stderrThreshold.Set("4") // Keep the stacks off stderr.
+	defer SetFlushHook(nil)
+	defer SetExitFunc(nil)
+	// Only the flushes of the fatal path must be recorded.
+	StopFlushDaemon()
+	defer StartFlushDaemon(flushInterval)
+
+	var events []string
+	SetFlushHook(func() { events = append(events, "flush") })
+	SetExitFunc(func(code int) { events = append(events, fmt.Sprintf("exit %d", code)) })
+
+	// Exit must not affect a later Fatal when the exit function returns.
+	Exit("exit")
+	Fatal("fatal")
+	expected := []string{"flush", "exit 1", "flush", "exit 255"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("expected %q, got %q", expected, events)
+	}
+	if !contains(fatalLog, "fatal", t) || !contains(fatalLog, "exit", t) {
+		t.Errorf("expected both messages in the fatal log, got %q", contents(fatalLog))
+	}
+
+	SetExitFunc(nil)
+	logging.mu.Lock()
+	reset := logging.exitFunc == nil
+	logging.mu.Unlock()
+	if !reset {
+		t.Error("expected SetExitFunc(nil) to restore the default")
+	}
+}
+
+func TestLogS(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+	defer func(previous func(error)) { logExitFunc = previous }(logExitFunc)
+	defer logging.stderrThreshold.Set("ERROR")
+	logging.stderrThreshold.Set("4") // Keep the stacks off stderr.
+	defer SetExitFunc(nil)
+	var code int
+	SetExitFunc(func(c int) { code = c })
+
+	for _, tc := range []struct {
+		severity string
+		sev      severity
+		err      error
+		want     string
+	}{
+		{severity: "INFO", sev: infoLog, want: `"test" pod="kubedns"`},
+		{severity: "info", sev: infoLog, err: errors.New("failed"), want: `"test" err="failed" pod="kubedns"`},
+		{severity: "Warning", sev: warningLog, err: errors.New("failed"), want: `"test" err="failed" pod="kubedns"`},
+		{severity: "ERROR", sev: errorLog, err: errors.New("failed"), want: `"test" err="failed" pod="kubedns"`},
+		{severity: "fatal", sev: fatalLog, want: `"test" pod="kubedns"`},
+	} {
+		t.Run(tc.severity, func(t *testing.T) {
+			logging.newBuffers()
+			code = 0
+			_, _, line, _ := runtime.Caller(0)
+			if err := LogS(tc.severity, tc.err, "test", "pod", "kubedns"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			want := fmt.Sprintf("klog_test.go:%d] %s\n", line+1, tc.want)
+			got := contents(tc.sev)
+			if tc.sev == fatalLog {
+				// Only check the entry, not the stacks.
+				got = got[:strings.Index(got, "\n")+1]
+				if code != 255 {
+					t.Errorf("expected exit code 255, got %d", code)
+				}
+			}
+			if !strings.HasPrefix(got, severityChar[tc.sev:tc.sev+1]) || !strings.HasSuffix(got, want) {
+				t.Errorf("expected %s entry ending with %q, got %q", severityName[tc.sev], want, got)
+			}
+			if tc.sev > infoLog && !contains(tc.sev-1, tc.want, t) {
+				t.Errorf("expected the entry in the %s log too", severityName[tc.sev-1])
+			}
+		})
+	}
+
+	logging.newBuffers()
+	if err := LogS("DEBUG", nil, "test"); err == nil {
+		t.Error("expected an error for an unknown severity")
+	}
+	for sev := infoLog; sev < numSeverity; sev++ {
+		if contents(sev) != "" {
+			t.Errorf("unexpected output for an unknown severity: %q", contents(sev))
+		}
+	}
+
+	SetLogFilter(&sampleLogFilter{})
+	defer SetLogFilter(nil)
+	for _, severity := range []string{"info", "warning", "error", "fatal"} {
+		logging.newBuffers()
+		LogS(severity, nil, "filter me", "password", "filter me")
+		if want := `"[FILTERED]" password="[FILTERED]"`; !contains(infoLog, want, t) {
+			t.Errorf("%s: expected %q, got %q", severity, want, contents(infoLog))
+		}
+	}
+
+	logger := &severityTestLogr{}
+	SetLogger(logger)
+	defer SetLogger(nil)
+	code = 0
+	for _, severity := range []string{"info", "warning", "error", "fatal"} {
+		LogS(severity, nil, "test", "password", "filter me")
+	}
+	if len(logger.entries) != 4 {
+		t.Fatalf("expected 4 entries, got %+v", logger.entries)
+	}
+	for i, entry := range logger.entries {
+		if want := []interface{}{"password", "[FILTERED]"}; entry.msg != "test" || !reflect.DeepEqual(entry.keysAndValues, want) {
+			t.Errorf("entry %d: expected %q with %v, got %+v", i, "test", want, entry)
+		}
+	}
+	if expected := []string{"INFO", "WARNING", "FATAL"}; !reflect.DeepEqual(logger.severities, expected) {
+		t.Errorf("expected severities %q, got %q", expected, logger.severities)
+	}
+	if code != 255 {
+		t.Errorf("expected exit code 255 after a fatal entry with a logger, got %d", code)
+	}
+}
+
+func TestFatalPanic(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+	defer func(previous func(int)) { fatalExitFunc = previous }(fatalExitFunc)
+	fatalExitFunc = func(code int) { t.Fatalf("unexpected exit with code %d", code) }
+	defer logging.stderrThreshold.Set("ERROR")
+	logging.stderrThreshold.Set("4")
+	SetFatalBehavior(FatalPanic)
+	defer SetFatalBehavior(FatalExit)
+
+	for name, fatal := range map[string]func(){
+		"Fatalf": func() { Fatalf("pod %s failed", "kubedns") },
+		"Exitf":  func() { Exitf("pod %s failed", "kubedns") },
+	} {
+		t.Run(name, func(t *testing.T) {
+			logging.newBuffers()
+			var recovered interface{}
+			func() {
+				defer func() { recovered = recover() }()
+				fatal()
+			}()
+			msg, ok := recovered.(string)
+			if !ok {
+				t.Fatalf("expected a string panic, got %#v", recovered)
+			}
+			if !strings.HasSuffix(msg, "] pod kubedns failed") {
+				t.Errorf("expected the log entry as panic value, got %q", msg)
+			}
+			if !contains(fatalLog, "pod kubedns failed", t) {
+				t.Errorf("expected the entry in the fatal log, got %q", contents(fatalLog))
+			}
+		})
+	}
+}
+
+func TestHostPID(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+
+	InfoS("disabled", "pod", "kubedns")
+	if strings.Contains(contents(infoLog), "host=") {
+		t.Errorf("unexpected host field while disabled: %q", contents(infoLog))
+	}
+
+	logging.hostPID = true
+	defer func() { logging.hostPID = false }()
+	logging.newBuffers()
+	InfoS("enabled", "pod", "kubedns")
+	ErrorS(errors.New("failed"), "enabled")
+	Info("unstructured")
+	for _, want := range []string{
+		fmt.Sprintf(`"enabled" pod="kubedns" host=%q pid=%d`, host, pid),
+		fmt.Sprintf(`"enabled" err="failed" host=%q pid=%d`, host, pid),
+	} {
+		if !contains(infoLog, want, t) {
+			t.Errorf("expected %q in output, got %q", want, contents(infoLog))
+		}
+	}
+	if !strings.HasSuffix(contents(infoLog), "] unstructured\n") {
+		t.Errorf("expected unstructured entries to be unchanged, got %q", contents(infoLog))
+	}
+}
+
+func TestUptime(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+
+	InfoS("disabled")
+	if strings.Contains(contents(infoLog), "uptime=") {
+		t.Errorf("unexpected uptime field while disabled: %q", contents(infoLog))
+	}
+
+	logging.uptime = true
+	defer func() { logging.uptime = false }()
+	logging.newBuffers()
+	InfoS("first")
+	time.Sleep(10 * time.Millisecond)
+	InfoS("second")
+
+	re := regexp.MustCompile(`"(first|second)" uptime="([^"]+)"`)
+	matches := re.FindAllStringSubmatch(contents(infoLog), -1)
+	if len(matches) != 2 {
+		t.Fatalf("expected two entries with uptime, got %q", contents(infoLog))
+	}
+	var uptimes []time.Duration
+	for _, match := range matches {
+		uptime, err := time.ParseDuration(match[2])
+		if err != nil {
+			t.Fatalf("unexpected uptime %q: %v", match[2], err)
+		}
+		uptimes = append(uptimes, uptime)
+	}
+	if uptimes[1]-uptimes[0] < 10*time.Millisecond {
+		t.Errorf("expected uptime to increase by at least 10ms, got %v and %v", uptimes[0], uptimes[1])
+	}
+}
+
+func TestDelta(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+	logging.delta = true
+	defer func() { logging.delta = false }()
+
+	InfoS("first")
+	time.Sleep(20 * time.Millisecond)
+	InfoS("second")
+
+	re := regexp.MustCompile(`"second" delta="([^"]+)"`)
+	match := re.FindStringSubmatch(contents(infoLog))
+	if match == nil {
+		t.Fatalf("expected an entry with delta, got %q", contents(infoLog))
+	}
+	delta, err := time.ParseDuration(match[1])
+	if err != nil {
+		t.Fatalf("unexpected delta %q: %v", match[1], err)
+	}
+	if delta < 20*time.Millisecond {
+		t.Errorf("expected a delta of at least the sleep time, got %v", delta)
+	}
+
+	// Entries logged concurrently never get a negative delta.
+	logging.newBuffers()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				InfoS("concurrent")
+			}
+		}()
+	}
+	wg.Wait()
+	re = regexp.MustCompile(`"concurrent" delta="([^"]+)"`)
+	for _, match := range re.FindAllStringSubmatch(contents(infoLog), -1) {
+		if delta, err := time.ParseDuration(match[1]); err != nil || delta < 0 {
+			t.Fatalf("unexpected delta %q: %v", match[1], err)
+		}
+	}
+}
+
+func TestLogfmtHeader(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+	logging.logfmt = true
+	defer func() { logging.logfmt = false }()
+	defer func(previous func() time.Time) { timeNow = previous }(timeNow)
+	timeNow = func() time.Time {
+		return time.Date(2006, 1, 2, 15, 4, 5, .067890e9, time.UTC)
+	}
+
+	_, _, line, _ := runtime.Caller(0)
+	Info("hello \"world\"")
+	InfoS("Pod status updated", "pod", "kubedns")
+	Warningf("%d left", 3)
+
+	expected := fmt.Sprintf(`level=info ts=2006-01-02T15:04:05.067890Z caller=klog_test.go:%d msg="hello \"world\""
+level=info ts=2006-01-02T15:04:05.067890Z caller=klog_test.go:%d msg="Pod status updated" pod="kubedns"
+level=warning ts=2006-01-02T15:04:05.067890Z caller=klog_test.go:%d msg="3 left"
+`, line+1, line+2, line+3)
+	if got := contents(infoLog); got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestNewWriter(t *testing.T) {
+	setFlags()
+	logging.oneOutput = true
+	defer func() { logging.oneOutput = false }()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+
+	w := NewWriter("WARNING", 0)
+	_, _, line, _ := runtime.Caller(0)
+	n, err := w.Write([]byte("first\nsecond\n"))
+	if err != nil || n != 13 {
+		t.Fatalf("expected 13 bytes written without error, got %d, %v", n, err)
+	}
+	fmt.Fprint(w, "third")
+	w.Write(nil)
+
+	lines := strings.Split(strings.TrimSuffix(contents(warningLog), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 entries, got %q", contents(warningLog))
+	}
+	for i, want := range []string{
+		fmt.Sprintf("klog_test.go:%d] first", line+1),
+		fmt.Sprintf("klog_test.go:%d] second", line+1),
+		"] third",
+	} {
+		if !strings.HasPrefix(lines[i], "W") || !strings.HasSuffix(lines[i], want) {
+			t.Errorf("expected warning entry ending with %q, got %q", want, lines[i])
+		}
+	}
+	if contents(infoLog) != "" {
+		t.Errorf("unexpected output at INFO severity: %q", contents(infoLog))
+	}
+}
+
+func TestNewWriterWithValues(t *testing.T) {
+	setFlags()
+	logging.oneOutput = true
+	defer func() { logging.oneOutput = false }()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+
+	w := NewWriterWithValues("WARNING", "component", "legacy", "id", 1)
+	_, _, line, _ := runtime.Caller(0)
+	w.Write([]byte("first\nsecond \"quoted\"\n"))
+
+	lines := strings.Split(strings.TrimSuffix(contents(warningLog), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 entries, got %q", contents(warningLog))
+	}
+	for i, want := range []string{
+		fmt.Sprintf(`klog_test.go:%d] "first" component="legacy" id=1`, line+1),
+		fmt.Sprintf(`klog_test.go:%d] "second \"quoted\"" component="legacy" id=1`, line+1),
+	} {
+		if !strings.HasPrefix(lines[i], "W") || !strings.HasSuffix(lines[i], want) {
+			t.Errorf("expected warning entry ending with %q, got %q", want, lines[i])
+		}
+	}
+}
+
+func TestNewWriterWithValuesFilterAndLogr(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+	SetLogFilter(&sampleLogFilter{})
+	defer SetLogFilter(nil)
+
+	w := NewWriterWithValues("WARNING", "password", "filter me")
+	w.Write([]byte("filter me please\n"))
+	if want := `"[FILTERED] please" password="[FILTERED]"`; !contains(warningLog, want, t) {
+		t.Errorf("expected %q in the warning log, got %q", want, contents(warningLog))
+	}
+
+	logger := &severityTestLogr{}
+	SetLogger(logger)
+	defer SetLogger(nil)
+	w.Write([]byte("hello\n"))
+	want := []interface{}{"password", "[FILTERED]"}
+	if len(logger.entries) != 1 || logger.entries[0].msg != "hello" || !reflect.DeepEqual(logger.entries[0].keysAndValues, want) {
+		t.Errorf("expected logr to receive %q with %v, got %+v", "hello", want, logger.entries)
+	}
+	if expected := []string{"WARNING"}; !reflect.DeepEqual(logger.severities, expected) {
+		t.Errorf("expected severities %q, got %q", expected, logger.severities)
+	}
+
+	logger = &severityTestLogr{}
+	SetLogger(logger)
+	NewWriterWithValues("ERROR", "pod", "kubedns").Write([]byte("failed\n"))
+	expected := []testLogrEntry{{severity: errorLog, msg: "failed", keysAndValues: []interface{}{"pod", "kubedns"}}}
+	if !reflect.DeepEqual(logger.entries, expected) {
+		t.Errorf("expected logr error entries %+v, got %+v", expected, logger.entries)
+	}
+	if len(logger.severities) != 0 {
+		t.Errorf("expected no InfoWithSeverity calls, got %q", logger.severities)
+	}
+}
+
+func TestNewWriterPanic(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error(`NewWriter("LOG", 0) should have panicked`)
+		}
+	}()
+	NewWriter("LOG", 0)
+}
+
+func TestCaptureStandardLogging(t *testing.T) {
+	setFlags()
+	logging.oneOutput = true
+	defer func() { logging.oneOutput = false }()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+
+	var previous bytes.Buffer
+	defer stdLog.SetOutput(stdLog.Writer())
+	defer stdLog.SetFlags(stdLog.Flags())
+	stdLog.SetOutput(&previous)
+	stdLog.SetFlags(stdLog.Lshortfile)
+
+	restore := CaptureStandardLogging()
+	_, _, line, _ := runtime.Caller(0)
+	stdLog.Print("plain message")
+	stdLog.Printf("ERROR: %s", "failed")
+	stdLog.Println("[warn] careful")
+	restore()
+	stdLog.Print("after restore")
+
+	for sev, want := range map[severity]string{
+		infoLog:    fmt.Sprintf("klog_test.go:%d] plain message\n", line+1),
+		errorLog:   fmt.Sprintf("klog_test.go:%d] ERROR: failed\n", line+2),
+		warningLog: fmt.Sprintf("klog_test.go:%d] [warn] careful\n", line+3),
+	} {
+		if got := contents(sev); !strings.HasPrefix(got, severityChar[sev:sev+1]) || !strings.HasSuffix(got, want) {
+			t.Errorf("expected single %s entry ending with %q, got %q", severityName[sev], want, got)
+		}
+	}
+	if strings.Contains(contents(infoLog), "after restore") {
+		t.Errorf("unexpected output after restore: %q", contents(infoLog))
+	}
+	if got := previous.String(); !strings.HasSuffix(got, "after restore\n") || !strings.HasPrefix(got, "klog_test.go:") {
+		t.Errorf("expected the previous output and flags to be restored, got %q", got)
+	}
+}
+
+func TestDebug(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+	defer logging.verbosity.Set("0")
+
+	logging.verbosity.Set("3")
+	Debug("hidden")
+	DebugS("hidden")
+	if contents(infoLog) != "" {
+		t.Errorf("expected no output below the default debug verbosity, got %q", contents(infoLog))
+	}
+
+	logging.verbosity.Set("4")
+	_, _, line, _ := runtime.Caller(0)
+	Debug("shown")
+	DebugS("shown", "pod", "kubedns")
+	for _, want := range []string{
+		fmt.Sprintf("klog_test.go:%d] shown\n", line+1),
+		fmt.Sprintf("klog_test.go:%d] \"shown\" pod=\"kubedns\"\n", line+2),
+	} {
+		if !contains(infoLog, want, t) {
+			t.Errorf("expected %q in output, got %q", want, contents(infoLog))
+		}
+	}
+	if !strings.HasPrefix(contents(infoLog), "I") {
+		t.Errorf("expected INFO entries, got %q", contents(infoLog))
+	}
+
+	SetDebugVerbosity(6)
+	defer SetDebugVerbosity(defaultDebugVerbosity)
+	logging.newBuffers()
+	DebugS("hidden again")
+	if contents(infoLog) != "" {
+		t.Errorf("expected no output below the changed debug verbosity, got %q", contents(infoLog))
+	}
+}
+
+// verbosityCallDepthTestLogr supports V so that it can back Verbose.
+type verbosityCallDepthTestLogr struct {
+	callDepthTestLogr
+}
+
+func (l *verbosityCallDepthTestLogr) V(int) logr.Logger { return l }
+
+func TestVInfoSDepthLogr(t *testing.T) {
+	logger := &verbosityCallDepthTestLogr{}
+	SetLogger(logger)
+	defer SetLogger(nil)
+	logging.verbosity.Set("2")
+	defer logging.verbosity.Set("0")
+
+	for name, logFn := range map[string]func(){
+		"InfoSDepth":  func() { myVInfoS("info") },
+		"ErrorSDepth": func() { myVErrorS(errors.New("update failed"), "error") },
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer logger.reset()
+			defer logger.resetCallDepth()
+
+			// Keep these lines together.
+			_, wantFile, wantLine, _ := runtime.Caller(0)
+			logFn()
+			wantLine++
+
+			if len(logger.entries) != 1 {
+				t.Fatalf("expected a single log entry to be generated, got %d", len(logger.entries))
+			}
+			checkLogrEntryCorrectCaller(t, wantFile, wantLine, logger.entries[0])
+		})
+	}
+}
+
+func TestSetCallSiteVerbosity(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+	logging.verbosity.Set("2")
+	defer logging.verbosity.Set("0")
+
+	logAll := func() {
+		V(2).Info("line a")
+		V(2).Info("line b")
+		V(5).Info("line c")
+	}
+	_, file, line, _ := runtime.Caller(0)
+	file = filepath.Base(file)
+	lineA, lineC := line-4, line-2
+
+	SetCallSiteVerbosity(file, lineA, 0) // silence
+	SetCallSiteVerbosity(file, lineC, 5) // enable
+	defer UnsetCallSiteVerbosity(file, lineA)
+	defer UnsetCallSiteVerbosity(file, lineC)
+
+	logAll()
+	for msg, want := range map[string]bool{"line a": false, "line b": true, "line c": true} {
+		if got := contains(infoLog, msg, t); got != want {
+			t.Errorf("%s: expected logged=%v, got output %q", msg, want, contents(infoLog))
+		}
+	}
+
+	// V calls on other lines of the same file are not affected.
+	if !V(2).Enabled() {
+		t.Error("expected V(2) on an unrelated line to be enabled")
+	}
+
+	UnsetCallSiteVerbosity(file, lineA)
+	UnsetCallSiteVerbosity(file, lineC)
+	logging.newBuffers()
+	logAll()
+	for msg, want := range map[string]bool{"line a": true, "line b": true, "line c": false} {
+		if got := contains(infoLog, msg, t); got != want {
+			t.Errorf("after unset, %s: expected logged=%v, got output %q", msg, want, contents(infoLog))
+		}
+	}
+	if n := atomic.LoadInt32(&logging.callSitesLength); n != 0 {
+		t.Errorf("expected no overrides after unset, got %d", n)
+	}
+}
+
+func TestKVList(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+
+	fields := []struct {
+		Key   string
+		Value interface{}
+	}{
+		{Key: "pod", Value: "kubedns"},
+		{Key: "replicas", Value: 3},
+	}
+
+	tests := []struct {
+		name          string
+		keysAndValues []interface{}
+		want          string
+	}{
+		{
+			name:          "only list",
+			keysAndValues: []interface{}{KVList(fields)},
+			want:          `"test" pod="kubedns" replicas=3`,
+		},
+		{
+			name:          "list first",
+			keysAndValues: []interface{}{KVList(fields), "status", "ready"},
+			want:          `"test" pod="kubedns" replicas=3 status="ready"`,
+		},
+		{
+			name:          "list last",
+			keysAndValues: []interface{}{"status", "ready", KVList(fields)},
+			want:          `"test" status="ready" pod="kubedns" replicas=3`,
+		},
+		{
+			name:          "two lists",
+			keysAndValues: []interface{}{KVList(fields), KVList(fields[:1])},
+			want:          `"test" pod="kubedns" replicas=3 pod="kubedns"`,
+		},
+		{
+			name:          "pointers",
+			keysAndValues: []interface{}{KVList([]*struct{ Key, Value string }{{Key: "a", Value: "b"}, nil})},
+			want:          `"test" a="b"`,
+		},
+		{
+			name:          "not a slice",
+			keysAndValues: []interface{}{KVList("pod"), "status", "ready"},
+			want:          `"test" status="ready"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logging.file[infoLog] = &flushBuffer{}
+			InfoS("test", tt.keysAndValues...)
+			if got := contents(infoLog); !strings.HasSuffix(got, "] "+tt.want+"\n") {
+				t.Errorf("expected output ending with %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	logger := new(testLogr)
+	SetLogger(logger)
+	defer SetLogger(nil)
+	InfoS("test", KVList(fields), "status", "ready")
+	want := []interface{}{"pod", "kubedns", "replicas", 3, "status", "ready"}
+	if len(logger.entries) != 1 || !reflect.DeepEqual(logger.entries[0].keysAndValues, want) {
+		t.Errorf("expected logr to receive %v, got %+v", want, logger.entries)
+	}
+}
+
+func TestWithCode(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+
+	ErrorS(errors.New("timeout"), "failed", WithCode("E1234"), "pod", "kubedns")
+	if want := `] "failed" err="timeout" code="E1234" pod="kubedns"` + "\n"; !strings.HasSuffix(contents(errorLog), want) {
+		t.Errorf("expected output ending with %q, got %q", want, contents(errorLog))
+	}
+
+	logger := new(testLogr)
+	SetLogger(logger)
+	defer SetLogger(nil)
+	ErrorS(errors.New("timeout"), "failed", WithCode("E1234"), "pod", "kubedns")
+	want := []interface{}{"code", "E1234", "pod", "kubedns"}
+	if len(logger.entries) != 1 || !reflect.DeepEqual(logger.entries[0].keysAndValues, want) {
+		t.Errorf("expected logr to receive %v, got %+v", want, logger.entries)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+
+	InfoS("Request done", Group("http", "method", "GET", "status", 200), "pod", "kubedns")
+	if want := `"Request done" http.method="GET" http.status=200 pod="kubedns"`; !contains(infoLog, want, t) {
+		t.Errorf("expected %q for a single group, got %q", want, contents(infoLog))
+	}
+
+	logging.newBuffers()
+	InfoS("Request done", Group("http", "method", "GET", Group("response", "status", 200, "size", 5)))
+	if want := `"Request done" http.method="GET" http.response.status=200 http.response.size=5`; !contains(infoLog, want, t) {
+		t.Errorf("expected %q for nested groups, got %q", want, contents(infoLog))
+	}
+
+	logger := new(testLogr)
+	SetLogger(logger)
+	defer SetLogger(nil)
+	InfoS("Request done", Group("http", "method", "GET", Group("response", "status", 200)), "pod", "kubedns")
+	want := []interface{}{
+		"http", map[string]interface{}{
+			"method":   "GET",
+			"response": map[string]interface{}{"status": 200},
+		},
+		"pod", "kubedns",
+	}
+	if len(logger.entries) != 1 || !reflect.DeepEqual(logger.entries[0].keysAndValues, want) {
+		t.Errorf("expected logr to receive %v, got %+v", want, logger.entries)
+	}
+
+	// WithCode and KVList take a single slot, like a group.
+	logger.entries = nil
+	InfoS("Request done", WithCode("E1"), Group("http", "method", "GET"), KVList([]struct {
+		Key   string
+		Value interface{}
+	}{{"pod", "kubedns"}}), Group("response", "status", 200))
+	want = []interface{}{
+		"code", "E1",
+		"http", map[string]interface{}{"method": "GET"},
+		"pod", "kubedns",
+		"response", map[string]interface{}{"status": 200},
+	}
+	if len(logger.entries) != 1 || !reflect.DeepEqual(logger.entries[0].keysAndValues, want) {
+		t.Errorf("expected logr to receive %v after WithCode and KVList, got %+v", want, logger.entries)
+	}
+}
+
+// blockingFlushBuffer is a flushSyncWriter whose Flush blocks until unblock is closed.
+type blockingFlushBuffer struct {
+	flushBuffer
+	unblock chan struct{}
+}
+
+func (f *blockingFlushBuffer) Flush() error {
+	<-f.unblock
+	return nil
+}
+
+func TestFlushWithTimeout(t *testing.T) {
+	defer logging.swap(logging.newBuffers())
+
+	if err := FlushWithTimeout(time.Second); err != nil {
+		t.Fatalf("unexpected error for a working writer: %v", err)
+	}
+
+	blocking := &blockingFlushBuffer{unblock: make(chan struct{})}
+	logging.mu.Lock()
+	logging.file[infoLog] = blocking
+	logging.mu.Unlock()
+
+	start := time.Now()
+	err := FlushWithTimeout(10 * time.Millisecond)
+	// Let the background flush complete before restoring the writers.
+	close(blocking.unblock)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("FlushWithTimeout returned only after %v", elapsed)
+	}
+}
+
+type failingSyncBuffer struct {
+	flushBuffer
+}
+
+func (f *failingSyncBuffer) Sync() error {
+	return errors.New("no space left on device")
+}
+
+func TestFlushE(t *testing.T) {
+	defer logging.swap(logging.newBuffers())
+
+	if err := FlushE(); err != nil {
+		t.Fatalf("unexpected error for working writers: %v", err)
+	}
+
+	failing := &failingSyncBuffer{}
+	logging.mu.Lock()
+	logging.file[warningLog] = failing
+	logging.file[errorLog] = failing
+	logging.mu.Unlock()
+
+	err := FlushE()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	// The shared writer is only synced once.
+	if expected := "sync ERROR: no space left on device"; err.Error() != expected {
+		t.Errorf("expected %q, got %q", expected, err.Error())
+	}
+}
+
+func TestFlushDaemon(t *testing.T) {
+	var flushed int32
+	daemon := newFlushDaemon(func() { atomic.AddInt32(&flushed, 1) })
+	if daemon.isRunning() {
+		t.Fatal("expected the daemon not to run before run is called")
+	}
+	daemon.stop() // No effect.
+
+	daemon.run(time.Hour)
+	daemon.run(time.Hour) // No effect.
+	if !daemon.isRunning() {
+		t.Fatal("expected the daemon to run")
+	}
+	daemon.stop()
+	if daemon.isRunning() {
+		t.Fatal("expected the daemon to be stopped")
+	}
+	if n := atomic.LoadInt32(&flushed); n != 1 {
+		t.Errorf("expected a single flush when stopping, got %d", n)
+	}
+	daemon.stop() // Idempotent.
+	if n := atomic.LoadInt32(&flushed); n != 1 {
+		t.Errorf("expected no flush when stopping twice, got %d", n)
+	}
+
+	daemon.run(time.Millisecond)
+	defer daemon.stop()
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&flushed) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected periodic flushes after restarting")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFlushHook(t *testing.T) {
+	defer logging.swap(logging.newBuffers())
+	defer SetFlushHook(nil)
+
+	flushed := make(chan struct{}, 10)
+	SetFlushHook(func() {
+		select {
+		case flushed <- struct{}{}:
+		default:
+		}
+	})
+	// Drain a flush of the global daemon that may have happened already.
+	for len(flushed) > 0 {
+		<-flushed
+	}
+
+	Flush()
+	select {
+	case <-flushed:
+	default:
+		t.Fatal("expected the hook to be called by Flush")
+	}
+	if err := FlushE(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case <-flushed:
+	default:
+		t.Fatal("expected the hook to be called by FlushE")
+	}
+
+	daemon := newFlushDaemon(logging.lockAndFlushAll)
+	daemon.run(time.Millisecond)
+	defer daemon.stop()
+	for i := 0; i < 3; i++ {
+		select {
+		case <-flushed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected periodic flushes, got %d", i)
+		}
+	}
+
+	SetFlushHook(nil)
+	Flush() // Must not panic.
+}
+
+func TestStopFlushDaemon(t *testing.T) {
+	defer StartFlushDaemon(flushInterval)
+
+	StopFlushDaemon()
+	if logging.flushD.isRunning() {
+		t.Fatal("expected the flush daemon to be stopped")
+	}
+	before := runtime.NumGoroutine()
+
+	StartFlushDaemon(time.Second)
+	if !logging.flushD.isRunning() {
+		t.Fatal("expected the flush daemon to run")
+	}
+
+	StopFlushDaemon()
+	StopFlushDaemon()
+	if logging.flushD.isRunning() {
+		t.Fatal("expected the flush daemon to be stopped again")
+	}
+	// The daemon goroutine may need a moment to exit after signaling that it is done.
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutine leaked: %d goroutines before start, %d after stop", before, runtime.NumGoroutine())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSequencePrefix(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+	logging.sequence = true
+	defer func() { logging.sequence = false }()
+	logging.mu.Lock()
+	logging.lastSequence = 0
+	logging.mu.Unlock()
+
+	for i := 0; i < 3; i++ {
+		Info("info")
+		Warning("warning")
+		ErrorS(errors.New("failed"), "error")
+	}
+
+	// The INFO log also contains the entries of all higher severities.
+	lines := strings.Split(strings.TrimSuffix(contents(infoLog), "\n"), "\n")
+	if len(lines) != 9 {
+		t.Fatalf("expected 9 lines, got %d: %q", len(lines), contents(infoLog))
+	}
+	for i, line := range lines {
+		fields := strings.SplitN(line, " ", 2)
+		seq, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			t.Fatalf("invalid sequence prefix in %q: %v", line, err)
+		}
+		if seq != uint64(i+1) {
+			t.Errorf("expected sequence number %d, got %d in %q", i+1, seq, line)
+		}
+	}
+	if want := "2 W"; !strings.HasPrefix(contents(warningLog), want) {
+		t.Errorf("expected the same sequence numbers in the WARNING log, got %q", contents(warningLog))
+	}
+}
+
+func TestMonotonicPrefix(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+	logging.monotonic = true
+	defer func() { logging.monotonic = false }()
+	// Force equal wall clock timestamps.
+	defer func(previous func() time.Time) { timeNow = previous }(timeNow)
+	timeNow = func() time.Time {
+		return time.Date(2006, 1, 2, 15, 4, 5, .067890e9, time.Local)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				Info("test")
+			}
+		}()
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSuffix(contents(infoLog), "\n"), "\n")
+	if len(lines) != 1000 {
+		t.Fatalf("expected 1000 lines, got %d", len(lines))
+	}
+	var last int64 = -1
+	for _, line := range lines {
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 || !strings.HasPrefix(fields[1], "I0102 15:04:05.067890") {
+			t.Fatalf("unexpected line format: %q", line)
+		}
+		mono, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			t.Fatalf("invalid monotonic prefix in %q: %v", line, err)
+		}
+		if mono <= last {
+			t.Fatalf("monotonic prefix not strictly increasing: %d after %d", mono, last)
+		}
+		last = mono
+	}
+}
+
+// pendingFlushBuffer is a flushSyncWriter which only makes written data
+// visible once it gets flushed.
+type pendingFlushBuffer struct {
+	mu      sync.Mutex
+	pending bytes.Buffer
+	flushed bytes.Buffer
+}
+
+func (f *pendingFlushBuffer) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.pending.Write(p)
+}
+
+func (f *pendingFlushBuffer) Flush() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err := f.pending.WriteTo(&f.flushed)
+	return err
+}
+
+func (f *pendingFlushBuffer) Sync() error {
+	return nil
+}
+
+func (f *pendingFlushBuffer) String() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.flushed.String()
+}
+
+func TestFlushFrequencyFlag(t *testing.T) {
+	setFlags()
+	logging.oneOutput = true
+	defer func() { logging.oneOutput = false }()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+	defer StartFlushDaemon(flushInterval)
+
+	fs := flag.NewFlagSet("test", flag.PanicOnError)
+	InitFlags(fs)
+	if got := fs.Lookup("log_flush_frequency").Value.String(); got != flushInterval.String() {
+		t.Errorf("expected default flush frequency %s, got %s", flushInterval, got)
+	}
+	if err := fs.Set("log_flush_frequency", "0s"); err == nil {
+		t.Error("expected an error for a zero flush frequency")
+	}
+
+	// Start out with a long interval so that nothing gets flushed by accident.
+	StartFlushDaemon(time.Hour)
+	buffer := &pendingFlushBuffer{}
+	logging.mu.Lock()
+	logging.file[infoLog] = buffer
+	logging.mu.Unlock()
+
+	if err := fs.Set("log_flush_frequency", "10ms"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := fs.Lookup("log_flush_frequency").Value.String(); got != "10ms" {
+		t.Errorf("expected flush frequency 10ms, got %s", got)
+	}
+	if !logging.flushD.isRunning() {
+		t.Fatal("expected the flush daemon to keep running")
+	}
+
+	Info("flushed by daemon")
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(buffer.String(), "flushed by daemon") {
+		if time.Now().After(deadline) {
+			t.Fatal("log data was not flushed periodically")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// A stopped daemon stays stopped.
+	StopFlushDaemon()
+	if err := fs.Set("log_flush_frequency", "20ms"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if logging.flushD.isRunning() {
+		t.Error("expected setting the flag not to start a stopped flush daemon")
+	}
+}
+
+func TestFlushFrequencyFlagConcurrentStop(t *testing.T) {
+	defer StartFlushDaemon(flushInterval)
+
+	fs := flag.NewFlagSet("test", flag.PanicOnError)
+	InitFlags(fs)
+	for i := 0; i < 100; i++ {
+		StartFlushDaemon(time.Hour)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fs.Set("log_flush_frequency", "1h"); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+		StopFlushDaemon()
+		wg.Wait()
+		if logging.flushD.isRunning() {
+			t.Fatal("expected the flush daemon to stay stopped")
+		}
+	}
+}
+
+func TestRotateByAge(t *testing.T) {
+	setFlags()
+	SetLogger(nil)
+	var err error
+	defer func(previous func(error)) { logExitFunc = previous }(logExitFunc)
+	logExitFunc = func(e error) {
+		err = e
+	}
+	defer func(previous func() time.Time) { timeNow = previous }(timeNow)
+	now := time.Date(2006, 1, 2, 15, 4, 5, 0, time.Local)
+	timeNow = func() time.Time { return now }
+	logging.logFile = ""
+	logging.logFileMaxAge = 24 * time.Hour
+	defer func() { logging.logFileMaxAge = 0 }()
+	defer logging.swap(logging.swap([numSeverity]flushSyncWriter{}))
+
+	Info("x") // Be sure we have a file.
+	info, ok := logging.file[infoLog].(*syncBuffer)
+	if !ok {
+		t.Fatal("info wasn't created")
+	}
+	if err != nil {
+		t.Fatalf("info has initial error: %v", err)
+	}
+	fname0 := info.file.Name()
+	defer os.Remove(fname0)
+
+	now = now.Add(23 * time.Hour)
+	Info("x")
+	if fname := info.file.Name(); fname != fname0 {
+		t.Fatalf("file rotated before reaching the maximum age: %s", fname)
+	}
+
+	now = now.Add(time.Hour)
+	Info("x")
+	if err != nil {
+		t.Fatalf("error after rotation: %v", err)
+	}
+	fname1 := info.file.Name()
+	defer os.Remove(fname1)
+	if fname1 == fname0 {
+		t.Fatalf("file was not rotated after reaching the maximum age: %s", fname0)
+	}
+	name, _ := logName("INFO", now)
+	if filepath.Base(fname1) != name {
+		t.Errorf("expected rotated file %s, got %s", name, filepath.Base(fname1))
+	}
+	link := filepath.Join(filepath.Dir(fname1), program+".INFO")
+	if target, err := os.Readlink(link); err != nil || target != name {
+		t.Errorf("expected symlink %s to point to %s, got %q, %v", link, name, target, err)
+	}
+
+	// Size-based rotation still applies within the age limit.
+	now = now.Add(time.Second)
+	info.maxbytes = info.nbytes + 10
+	Info(strings.Repeat("x", 10))
+	fname2 := info.file.Name()
+	defer os.Remove(fname2)
+	if fname2 == fname1 {
+		t.Errorf("file was not rotated after reaching the maximum size: %s", fname1)
+	}
+}
+
+func TestRemoveOldLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test_klog_RemoveOldLogs")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var names []string
+	for i := 1; i <= 5; i++ {
+		name, _ := logName("INFO", time.Date(2006, 1, i, 15, 4, 5, 0, time.Local))
+		names = append(names, name)
+	}
+	warning, _ := logName("WARNING", time.Date(2006, 1, 1, 15, 4, 5, 0, time.Local))
+	unrelated := []string{
+		warning,
+		"unrelated.log",
+		names[0] + ".bak",
+		program + ".INFO",
+	}
+	for _, name := range append(names, unrelated...) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	removeOldLogs(dir, "INFO", names[4], 3)
+
+	for i, name := range names {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if exists := err == nil; exists != (i >= 2) {
+			t.Errorf("%s: expected exists=%v, got %v", name, i >= 2, err)
+		}
+	}
+	for _, name := range unrelated {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected unrelated file %s to be kept: %v", name, err)
+		}
+	}
+}
+
+func TestRemoveOldLogsCompressed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test_klog_RemoveOldLogsCompressed")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var names []string
+	for i := 1; i <= 5; i++ {
+		name, _ := logName("INFO", time.Date(2006, 1, i, 15, 4, 5, 0, time.Local))
+		names = append(names, name)
+	}
+	// names[2] is being compressed.
+	for _, name := range append(names, names[2]+".gz") {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(dir, name))
+		return err == nil
+	}
+
+	removeOldLogs(dir, "INFO", names[4], 3)
+	if exists(names[0]) || exists(names[1]) {
+		t.Errorf("expected %s and %s to be removed", names[0], names[1])
+	}
+	if !exists(names[2]) || !exists(names[2]+".gz") {
+		t.Errorf("expected %s and its compressed copy to count as one file and be kept", names[2])
+	}
+
+	path := filepath.Join(dir, names[2])
+	compressing.Lock()
+	compressing.files[path] = true
+	compressing.Unlock()
+	removeOldLogs(dir, "INFO", names[4], 2)
+	if !exists(names[2]) {
+		t.Errorf("expected %s to be kept while it is compressed", names[2])
+	}
+
+	compressing.Lock()
+	delete(compressing.files, path)
+	compressing.Unlock()
+	removeOldLogs(dir, "INFO", names[4], 2)
+	if exists(names[2]) || exists(names[2]+".gz") {
+		t.Errorf("expected %s and its compressed copy to be removed", names[2])
+	}
+	if !exists(names[3]) {
+		t.Errorf("expected %s to be kept", names[3])
+	}
+}
+
+func TestRotateMaxCount(t *testing.T) {
+	setFlags()
+	SetLogger(nil)
+	var err error
+	defer func(previous func(error)) { logExitFunc = previous }(logExitFunc)
+	logExitFunc = func(e error) {
+		err = e
+	}
+	// Use dates in the future so that the files created here are the newest ones.
+	defer func(previous func() time.Time) { timeNow = previous }(timeNow)
+	now := time.Date(2106, 1, 2, 15, 4, 5, 0, time.Local)
+	timeNow = func() time.Time { return now }
+	logging.logFile = ""
+	logging.logFileMaxCount = 2
+	defer func() { logging.logFileMaxCount = 0 }()
+	defer logging.swap(logging.swap([numSeverity]flushSyncWriter{}))
+
+	var fnames []string
+	for i := 0; i < 4; i++ {
+		Info("x")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		info := logging.file[infoLog].(*syncBuffer)
+		fnames = append(fnames, info.file.Name())
+		defer os.Remove(info.file.Name())
+		// Force a rotation with the next write.
+		info.maxbytes = info.nbytes
+		now = now.Add(time.Second)
+	}
+
+	for i, fname := range fnames {
+		_, err := os.Stat(fname)
+		if exists := err == nil; exists != (i >= 2) {
+			t.Errorf("%s: expected exists=%v, got %v", fname, i >= 2, err)
+		}
+	}
+}
+
+func TestRotateCompress(t *testing.T) {
+	setFlags()
+	SetLogger(nil)
+	var err error
+	defer func(previous func(error)) { logExitFunc = previous }(logExitFunc)
+	logExitFunc = func(e error) {
+		err = e
+	}
+	defer func(previous func() time.Time) { timeNow = previous }(timeNow)
+	now := time.Date(2106, 2, 3, 15, 4, 5, 0, time.Local)
+	timeNow = func() time.Time { return now }
+	logging.logFile = ""
+	logging.logFileCompress = true
+	defer func() { logging.logFileCompress = false }()
+	defer logging.swap(logging.swap([numSeverity]flushSyncWriter{}))
+
+	Info("before rotation")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	info := logging.file[infoLog].(*syncBuffer)
+	fname := info.file.Name()
+	defer os.Remove(fname)
+	defer os.Remove(fname + ".gz")
+	info.maxbytes = info.nbytes
+	now = now.Add(time.Second)
+	Info("after rotation")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Remove(info.file.Name())
+
+	// Compression happens in the background.
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, err := os.Stat(fname); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s was not replaced by a compressed file", fname)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	f, err := os.Open(fname + ".gz")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(data), "before rotation") {
+		t.Errorf("expected the rotated entry in the compressed file, got %q", data)
+	}
+	if strings.Contains(string(data), "after rotation") {
+		t.Errorf("unexpected entry from the new file in the compressed file: %q", data)
+	}
+}
+
+func TestLogFilePath(t *testing.T) {
+	setFlags()
+	SetLogger(nil)
+	var err error
+	defer func(previous func(error)) { logExitFunc = previous }(logExitFunc)
+	logExitFunc = func(e error) {
+		err = e
+	}
+	defer func(previous func() time.Time) { timeNow = previous }(timeNow)
+	now := time.Date(2106, 3, 4, 15, 4, 5, 0, time.Local)
+	timeNow = func() time.Time { return now }
+	logging.logFile = ""
+	defer logging.swap(logging.swap([numSeverity]flushSyncWriter{}))
+
+	if path, ok := LogFilePath("INFO"); ok {
+		t.Fatalf("expected no path before logging, got %q", path)
+	}
+	if _, ok := LogFilePath("DEBUG"); ok {
+		t.Fatal("expected no path for an unknown severity")
+	}
+
+	Info("first file")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	path0, ok := LogFilePath("info")
+	if !ok {
+		t.Fatal("expected a path after logging")
+	}
+	defer os.Remove(path0)
+	if !filepath.IsAbs(path0) {
+		t.Errorf("expected an absolute path, got %q", path0)
+	}
+	Flush()
+	data, err := ioutil.ReadFile(path0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(data), "first file") {
+		t.Errorf("expected the entry in %s, got %q", path0, data)
+	}
+
+	// Force a rotation with the next write.
+	info := logging.file[infoLog].(*syncBuffer)
+	info.maxbytes = info.nbytes
+	now = now.Add(time.Second)
+	Info("second file")
+	path1, ok := LogFilePath("INFO")
+	if !ok {
+		t.Fatal("expected a path after rotation")
+	}
+	defer os.Remove(path1)
+	if path1 == path0 {
+		t.Errorf("expected a new path after rotation, got %q again", path1)
+	}
+
+	logging.toStderr = true
+	if path, ok := LogFilePath("INFO"); ok {
+		t.Errorf("expected no path when logging to stderr, got %q", path)
+	}
+}
+
+func TestLogFilePathSingleFile(t *testing.T) {
+	setFlags()
+	SetLogger(nil)
+	var err error
+	defer func(previous func(error)) { logExitFunc = previous }(logExitFunc)
+	logExitFunc = func(e error) {
+		err = e
+	}
+	dir, err := ioutil.TempDir("", "test_klog_LogFilePathSingleFile")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(previous string) { logging.logFile = previous }(logging.logFile)
+	logging.logFile = filepath.Join(dir, "test.log")
+	defer logging.swap(logging.swap([numSeverity]flushSyncWriter{}))
+
+	Error("error")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, severity := range []string{"INFO", "WARNING", "ERROR", "FATAL"} {
+		if path, ok := LogFilePath(severity); !ok || path != logging.logFile {
+			t.Errorf("%s: expected %q, got %q, %v", severity, logging.logFile, path, ok)
+		}
+	}
+}
+
+func TestLogFilePattern(t *testing.T) {
+	setFlags()
+	SetLogger(nil)
+	var err error
+	defer func(previous func(error)) { logExitFunc = previous }(logExitFunc)
+	logExitFunc = func(e error) {
+		err = e
+	}
+	defer func(previous func() time.Time) { timeNow = previous }(timeNow)
+	now := time.Date(2006, 1, 2, 15, 4, 5, 0, time.Local)
+	timeNow = func() time.Time { return now }
+	logging.logFile = ""
+	dir, tmpErr := ioutil.TempDir("", "klog-pattern")
+	if tmpErr != nil {
+		t.Fatalf("unexpected error: %v", tmpErr)
+	}
+	defer os.RemoveAll(dir)
+	SetLogFilePattern(filepath.Join(dir, "app-{severity}-{pid}-{host}-{date}.log"))
+	defer SetLogFilePattern("")
+	defer logging.swap(logging.swap([numSeverity]flushSyncWriter{}))
+
+	Warning("x")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, sev := range []string{"INFO", "WARNING"} {
+		expected := filepath.Join(dir, fmt.Sprintf("app-%s-%d-%s-20060102-150405.log", sev, pid, host))
+		if path, _ := LogFilePath(sev); path != expected {
+			t.Errorf("expected %s file %s, got %s", sev, expected, path)
+		}
+	}
+
+	// Force a rotation with the next write.
+	info := logging.file[infoLog].(*syncBuffer)
+	info.maxbytes = info.nbytes
+	now = now.Add(time.Second)
+	Info("x")
+	if err != nil {
+		t.Fatalf("error after rotation: %v", err)
+	}
+	expected := filepath.Join(dir, fmt.Sprintf("app-INFO-%d-%s-20060102-150406.log", pid, host))
+	if path, _ := LogFilePath("INFO"); path != expected {
+		t.Errorf("expected rotated file %s, got %s", expected, path)
+	}
+	entries, _ := ioutil.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Errorf("expected two INFO files and one WARNING file, got %d entries", len(entries))
+	}
+}
+
+func TestOnRotate(t *testing.T) {
+	setFlags()
+	SetLogger(nil)
+	var err error
+	defer func(previous func(error)) { logExitFunc = previous }(logExitFunc)
+	logExitFunc = func(e error) {
+		err = e
+	}
+	defer func(previous func() time.Time) { timeNow = previous }(timeNow)
+	now := time.Date(2106, 4, 5, 15, 4, 5, 0, time.Local)
+	timeNow = func() time.Time { return now }
+	logging.logFile = ""
+	defer logging.swap(logging.swap([numSeverity]flushSyncWriter{}))
+	defer func() { logging.rotateHooks = nil }()
+
+	type call struct{ oldPath, newPath string }
+	var calls []call
+	var info *syncBuffer
+	OnRotate(func(oldPath, newPath string) {
+		calls = append(calls, call{oldPath, newPath})
+		// Logging from within the callback must not deadlock. Restore the
+		// size limit first, otherwise that would rotate again.
+		info.maxbytes = CalculateMaxSize()
+		Info("rotated")
+	})
+
+	Info("x")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	info = logging.file[infoLog].(*syncBuffer)
+	fname0 := info.file.Name()
+	defer os.Remove(fname0)
+	if len(calls) != 0 {
+		t.Fatalf("unexpected callback for the initial file: %v", calls)
+	}
+
+	info.maxbytes = info.nbytes
+	now = now.Add(time.Second)
+	Info("x")
+	fname1 := info.file.Name()
+	defer os.Remove(fname1)
+	if len(calls) != 1 {
+		t.Fatalf("expected one callback, got %v", calls)
+	}
+	if calls[0].oldPath != fname0 || calls[0].newPath != fname1 {
+		t.Errorf("expected callback with %q and %q, got %v", fname0, fname1, calls[0])
+	}
+}
+
+type severityTestLogr struct {
+	testLogr
+	severities []string
+}
+
+func (l *severityTestLogr) InfoWithSeverity(severity string, msg string, keysAndValues ...interface{}) {
+	l.severities = append(l.severities, severity)
+	l.Info(msg, keysAndValues...)
+}
+
+func TestSeverityLogger(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	logger := &severityTestLogr{}
+	SetLogger(logger)
+	defer SetLogger(nil)
+
+	Info("info")
+	Warning("warning")
+	Error("error")
+	InfoS("structured")
+
+	if expected := []string{"INFO", "WARNING", "INFO"}; !reflect.DeepEqual(logger.severities, expected) {
+		t.Errorf("expected severities %q, got %q", expected, logger.severities)
+	}
+	if len(logger.entries) != 4 {
+		t.Fatalf("expected 4 entries, got %d", len(logger.entries))
+	}
+	if logger.entries[2].severity != errorLog {
+		t.Errorf("expected error entry, got %+v", logger.entries[2])
+	}
+}
+
+func TestValueRedactor(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+	defer SetValueRedactor(nil)
+
+	SetValueRedactor(func(key string, value interface{}) interface{} {
+		key = strings.ToLower(key)
+		if strings.Contains(key, "password") || strings.Contains(key, "token") {
+			return "[REDACTED]"
+		}
+		return value
+	})
+	InfoS("login", "user", "admin", "userPassword", "secret", "bearerToken", []byte("abc"), "attempt", 2)
+	want := `"login" user="admin" userPassword="[REDACTED]" bearerToken="[REDACTED]" attempt=2`
+	if !contains(infoLog, want, t) {
+		t.Errorf("expected %q in output, got %q", want, contents(infoLog))
+	}
+
+	logger := &testLogr{}
+	SetLogger(logger)
+	defer SetLogger(nil)
+	InfoS("login", "user", "admin", "password", "secret")
+	ErrorS(errors.New("failed"), "login", "token", "abc")
+	expected := []testLogrEntry{
+		{severity: infoLog, msg: "login", keysAndValues: []interface{}{"user", "admin", "password", "[REDACTED]"}},
+		{severity: errorLog, msg: "login", keysAndValues: []interface{}{"token", "[REDACTED]"}, err: errors.New("failed")},
+	}
+	if !reflect.DeepEqual(logger.entries, expected) {
+		t.Errorf("expected redacted logger entries %+v, got %+v", expected, logger.entries)
+	}
+	SetLogger(nil)
+
+	SetValueRedactor(nil)
+	logging.newBuffers()
+	InfoS("login", "password", "secret")
+	if want := `"login" password="secret"`; !contains(infoLog, want, t) {
+		t.Errorf("expected %q after removing the redactor, got %q", want, contents(infoLog))
+	}
+}
+
+// fakeProto has the methods of a message generated by protoc-gen-go.
+type fakeProto struct {
+	Name  string
+	Count int
+}
+
+func (m *fakeProto) Reset()         { *m = fakeProto{} }
+func (m *fakeProto) String() string { return fmt.Sprintf("{%q, %d}", m.Name, m.Count) }
+func (*fakeProto) ProtoMessage()    {}
+
+func TestProtoFormatter(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+	defer SetProtoFormatter(nil)
+
+	msg := &fakeProto{Name: "kubedns", Count: 2}
+	InfoS("update", "msg", msg)
+	if want := `"update" msg="{\"kubedns\", 2}"`; !contains(infoLog, want, t) {
+		t.Errorf("expected %q without a formatter, got %q", want, contents(infoLog))
+	}
+
+	var formatted []interface{}
+	SetProtoFormatter(func(m interface{}) (string, bool) {
+		formatted = append(formatted, m)
+		p, ok := m.(*fakeProto)
+		if !ok || p.Name == "" {
+			return "", false
+		}
+		return fmt.Sprintf("name:%q count:%d", p.Name, p.Count), true
+	})
+	logging.newBuffers()
+	InfoS("update", "msg", msg, "empty", &fakeProto{}, "pod", "kubedns")
+	if want := `"update" msg="name:\"kubedns\" count:2" empty="{\"\", 0}" pod="kubedns"`; !contains(infoLog, want, t) {
+		t.Errorf("expected %q with a formatter, got %q", want, contents(infoLog))
+	}
+	if len(formatted) != 2 {
+		t.Errorf("expected the formatter to be called for the two messages only, got %v", formatted)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+
+	type credentials struct {
+		User     string
+		Password fmt.Stringer `json:"password"`
+	}
+	creds := credentials{User: "admin", Password: Redacted("secret")}
+
+	InfoS("login", "token", Redacted("abc"), "creds", creds)
+	want := `"login" token="[REDACTED]" creds={User:admin Password:[REDACTED]}`
+	if !contains(infoLog, want, t) {
+		t.Errorf("expected %q in output, got %q", want, contents(infoLog))
+	}
+	if strings.Contains(contents(infoLog), "secret") || strings.Contains(contents(infoLog), "abc") {
+		t.Errorf("redacted value leaked: %q", contents(infoLog))
+	}
+
+	data, err := json.Marshal(creds)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := `{"User":"admin","password":"[REDACTED]"}`; string(data) != expected {
+		t.Errorf("expected %s, got %s", expected, data)
+	}
+	if got := fmt.Sprintf("%#v", Redacted("secret")); got != "[REDACTED]" {
+		t.Errorf("expected placeholder for %%#v, got %q", got)
+	}
+	marshaler, ok := Redacted("secret").(interface{ MarshalLog() interface{} })
+	if !ok {
+		t.Fatal("expected Redacted value to implement MarshalLog")
+	}
+	if got := marshaler.MarshalLog(); got != "[REDACTED]" {
+		t.Errorf("expected MarshalLog to return the placeholder, got %#v", got)
+	}
+}
+
+func ExampleRedacted() {
+	fmt.Println(Redacted("my-secret-token"))
+	// Output: [REDACTED]
+}
+
+func TestLoggingEnabled(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	defer SetLogger(nil)
+	defer logging.verbosity.Set("0")
+	defer logging.vmodule.Set("")
+
+	if !LoggingEnabled() {
+		t.Error("expected logging to be enabled without a logger")
+	}
+	SetLogger(logr.Discard())
+	if LoggingEnabled() {
+		t.Error("expected logging to be disabled with the discard logger")
+	}
+	logging.verbosity.Set("1")
+	if !LoggingEnabled() {
+		t.Error("expected logging to be enabled with -v=1")
+	}
+	logging.verbosity.Set("0")
+	logging.vmodule.Set("klog_test=2")
+	if !LoggingEnabled() {
+		t.Error("expected logging to be enabled with -vmodule")
+	}
+	logging.vmodule.Set("")
+	SetLogger(&testLogr{})
+	if !LoggingEnabled() {
+		t.Error("expected logging to be enabled with a logger")
+	}
+}
+
+func BenchmarkLoggingEnabled(b *testing.B) {
+	SetLogger(logr.Discard())
+	defer SetLogger(nil)
+	for i := 0; i < b.N; i++ {
+		if LoggingEnabled() {
+			b.Fatal("expected logging to be disabled")
+		}
+	}
+}
+
+func TestReopenLogFiles(t *testing.T) {
+	setFlags()
+	SetLogger(nil)
+	var err error
+	defer func(previous func(error)) { logExitFunc = previous }(logExitFunc)
+	logExitFunc = func(e error) {
+		err = e
+	}
+	dir, err := ioutil.TempDir("", "test_klog_ReopenLogFiles")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(previous string) { logging.logFile = previous }(logging.logFile)
+	logging.logFile = filepath.Join(dir, "test.log")
+	defer logging.swap(logging.swap([numSeverity]flushSyncWriter{}))
+
+	Info("before rotation")
+	rotated := logging.logFile + ".1"
+	if err := os.Rename(logging.logFile, rotated); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Buffered, so it goes to the old file once that gets flushed.
+	Info("buffered")
+	if err := ReopenLogFiles(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	Info("after rotation")
+	Flush()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for file, expected := range map[string][]string{
+		rotated:         {"before rotation", "buffered"},
+		logging.logFile: {"after rotation"},
+	} {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, entry := range expected {
+			if !strings.Contains(string(data), entry) {
+				t.Errorf("expected %q in %s, got %q", entry, file, data)
+			}
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+
+	stats := map[string]*OutputStats{"INFO": &Stats.Info, "WARNING": &Stats.Warning, "ERROR": &Stats.Error}
+	before := map[string]int64{}
+	for severity, s := range stats {
+		before[severity] = s.Lines()
+	}
+	infoBytes := Stats.Info.Bytes()
+	Info("info")
+	InfoS("info")
+	V(10).Info("disabled")
+	Warning("warning")
+	Error("error")
+	ErrorS(errors.New("failed"), "error")
+	Error("error")
+
+	for severity, expected := range map[string]int64{"INFO": 2, "WARNING": 1, "ERROR": 3} {
+		if got := stats[severity].Lines() - before[severity]; got != expected {
+			t.Errorf("expected %d new %s lines, got %d", expected, severity, got)
+		}
+	}
+	// The INFO output contains the entries of all severities.
+	if got, expected := Stats.Info.Bytes()-infoBytes, int64(len(contents(infoLog))-len(contents(warningLog))); got != expected {
+		t.Errorf("expected %d new INFO bytes, got %d", expected, got)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	setFlags()
+	logging.oneOutput = true
+	defer func() { logging.oneOutput = false }()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+	logging.dedup = true
+	defer func() {
+		logging.dedup = false
+		logging.repeats = [numSeverity]repeatState{}
+	}()
+
+	for i := 0; i < 3; i++ {
+		Info("flapping")
+	}
+	Info("different")
+	Info("flapping")
+	// Interleaved warnings are tracked separately.
+	Info("repeated")
+	Warning("warning")
+	Info("repeated")
+	Warning("warning")
+	Info("repeated")
+	Flush()
+
+	var messages []string
+	for _, line := range strings.Split(strings.TrimSuffix(contents(infoLog), "\n"), "\n") {
+		messages = append(messages, line[strings.Index(line, "] ")+2:])
+	}
+	expected := []string{
+		"flapping",
+		"(last message repeated 2 times)",
+		"different",
+		"flapping",
+		"repeated",
+		"(last message repeated 2 times)",
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("expected INFO messages %q, got %q", expected, messages)
+	}
+	if !contains(warningLog, "] (last message repeated 1 times)\n", t) || strings.Count(contents(warningLog), "] warning\n") != 1 {
+		t.Errorf("unexpected WARNING output %q", contents(warningLog))
+	}
+}
+
+func TestBufferUntilError(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+	defer SetBufferUntilError(false)
+
+	SetBufferUntilError(true)
+	Info("info")
+	Warning("warning")
+	if contents(infoLog) != "" || contents(warningLog) != "" {
+		t.Fatalf("expected no output before an error, got %q", contents(infoLog))
+	}
+	Error("error")
+	Info("after error")
+	var messages []string
+	for _, line := range strings.Split(strings.TrimSuffix(contents(infoLog), "\n"), "\n") {
+		messages = append(messages, line[strings.Index(line, "] ")+2:])
+	}
+	if expected := []string{"info", "warning", "error", "after error"}; !reflect.DeepEqual(messages, expected) {
+		t.Errorf("expected INFO messages %q, got %q", expected, messages)
+	}
+
+	logging.newBuffers()
+	SetBufferUntilError(true)
+	Info("flushed")
+	logging.lockAndFlushDaemon()
+	if contents(infoLog) != "" {
+		t.Errorf("expected the flush daemon to keep held entries, got %q", contents(infoLog))
+	}
+	Flush()
+	if !contains(infoLog, "] flushed\n", t) {
+		t.Errorf("expected held entry after Flush, got %q", contents(infoLog))
+	}
+	Info("discarded")
+	SetBufferUntilError(false)
+	Info("direct")
+	Flush()
+	if contains(infoLog, "discarded", t) || !contains(infoLog, "] direct\n", t) {
+		t.Errorf("unexpected output after disabling the mode: %q", contents(infoLog))
+	}
+}
+
+func TestBufferUntilErrorLimit(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+	defer SetBufferUntilError(false)
+
+	SetBufferUntilError(true)
+	for i := 0; i < maxHeldEntries+5; i++ {
+		Infof("entry %d", i)
+	}
+	if len(logging.held) != maxHeldEntries {
+		t.Errorf("expected %d held entries, got %d", maxHeldEntries, len(logging.held))
+	}
+	Flush()
+	lines := strings.Split(strings.TrimSuffix(contents(infoLog), "\n"), "\n")
+	if len(lines) != maxHeldEntries {
+		t.Fatalf("expected %d lines, got %d", maxHeldEntries, len(lines))
+	}
+	if !strings.HasSuffix(lines[0], "] entry 5") || !strings.HasSuffix(lines[len(lines)-1], fmt.Sprintf("] entry %d", maxHeldEntries+4)) {
+		t.Errorf("expected the oldest entries to be dropped, got %q ... %q", lines[0], lines[len(lines)-1])
+	}
+}
+
+func TestBufferUntilErrorDedup(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+	defer SetBufferUntilError(false)
+	logging.dedup = true
+	defer func() {
+		logging.dedup = false
+		logging.repeats = [numSeverity]repeatState{}
+	}()
+
+	SetBufferUntilError(true)
+	for i := 0; i < 3; i++ {
+		Info("flapping")
+	}
+	Info("different")
+	Info("flapping")
+	logging.lockAndFlushDaemon()
+	if contents(infoLog) != "" {
+		t.Fatalf("expected the summary to be held, got %q", contents(infoLog))
+	}
+	Flush()
+	var messages []string
+	for _, line := range strings.Split(strings.TrimSuffix(contents(infoLog), "\n"), "\n") {
+		messages = append(messages, line[strings.Index(line, "] ")+2:])
+	}
+	expected := []string{"flapping", "(last message repeated 2 times)", "different", "flapping"}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("expected %q, got %q", expected, messages)
+	}
+}
+
+func TestLogFileThreshold(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	SetLogger(nil)
+	defer logging.fileThreshold.set(infoLog)
+	defer logging.stderrThreshold.set(logging.stderrThreshold.get())
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func(previous *os.File) { os.Stderr = previous }(os.Stderr)
+	os.Stderr = w
+
+	if err := SetLogFileThreshold("WARNING"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := SetStderrThreshold("INFO"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	Info("info")
+	Warning("warning")
+	w.Close()
+	stderr, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if contains(infoLog, "] info", t) || !contains(infoLog, "] warning", t) || !contains(warningLog, "] warning", t) {
+		t.Errorf("expected only the warning in the files, got %q", contents(infoLog))
+	}
+	if !strings.Contains(string(stderr), "] info") || !strings.Contains(string(stderr), "] warning") {
+		t.Errorf("expected both entries on stderr, got %q", stderr)
+	}
+}
@@ -25,3 +25,11 @@ func myInfoS(msg string, keyAndValues ...interface{}) {
 func myErrorS(err error, msg string, keyAndValues ...interface{}) {
 	ErrorSDepth(1, err, msg, keyAndValues...)
 }
+
+func myVInfoS(msg string, keyAndValues ...interface{}) {
+	V(2).InfoSDepth(1, msg, keyAndValues...)
+}
+
+func myVErrorS(err error, msg string, keyAndValues ...interface{}) {
+	V(2).ErrorSDepth(1, err, msg, keyAndValues...)
+}
@@ -0,0 +1,130 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package klog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/go-logr/logr"
+)
+
+// NewLoggerToWriter returns a logger which formats entries like the structured
+// klog functions (InfoS, ErrorS) and writes them to w instead of the global
+// klog output. The log files, -logtostderr, SetOutput and SetLogger do not
+// apply to it, but -v and -vmodule do.
+//
+// Loggers derived from the result with V, WithName and WithValues write to
+// the same w. Writes are serialized, so w does not need to be safe for
+// concurrent use by itself.
+//
+// Basic example:
+// >> logger := klog.NewLoggerToWriter(&buffer)
+// >> logger.Info("Pod status updated", "pod", "kubedns")
+// output:
+// >> I1025 00:15:15.525108       1 controller_utils.go:116] "Pod status updated" pod="kubedns"
+func NewLoggerToWriter(w io.Writer) logr.Logger {
+	return writerLogger{out: &lockedWriter{w: w}}
+}
+
+// lockedWriter serializes the writes of all loggers sharing it.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (lw *lockedWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.w.Write(p)
+}
+
+// writerLogger implements NewLoggerToWriter.
+type writerLogger struct {
+	out    *lockedWriter
+	level  Level
+	names  []string
+	values []interface{}
+	depth  int
+}
+
+var _ logr.CallDepthLogger = writerLogger{}
+var _ LoggerInfoProvider = writerLogger{}
+
+func (l writerLogger) Enabled() bool {
+	return vDepth(l.depth, l.level).Enabled()
+}
+
+func (l writerLogger) Info(msg string, keysAndValues ...interface{}) {
+	if !vDepth(l.depth, l.level).Enabled() {
+		return
+	}
+	l.output(infoLog, nil, msg, keysAndValues)
+}
+
+func (l writerLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	l.output(errorLog, err, msg, keysAndValues)
+}
+
+// output formats one entry. It must be called directly by Info or Error for
+// the caller to be correct.
+func (l writerLogger) output(s severity, err error, msg string, keysAndValues []interface{}) {
+	buf, _, _ := logging.header(s, l.depth)
+	defer logging.putBuffer(buf)
+	if prefix := RenderName(l.names); prefix != "" {
+		msg = prefix + ": " + msg
+	}
+	b := &bytes.Buffer{}
+	b.WriteString(fmt.Sprintf("%q", msg))
+	if err != nil {
+		b.WriteByte(' ')
+		b.WriteString(fmt.Sprintf("err=%q", err.Error()))
+	}
+	kvListFormat(b, l.values...)
+	kvListFormat(b, expandKVLists(keysAndValues)...)
+	buf.Write(b.Bytes())
+	buf.WriteByte('\n')
+	l.out.Write(buf.Bytes()) // ignore err, like the other outputs without a file
+}
+
+func (l writerLogger) V(level int) logr.Logger {
+	l.level += Level(level)
+	return l
+}
+
+func (l writerLogger) WithName(name string) logr.Logger {
+	names := make([]string, 0, len(l.names)+1)
+	l.names = append(append(names, l.names...), name)
+	return l
+}
+
+func (l writerLogger) WithValues(keysAndValues ...interface{}) logr.Logger {
+	values := make([]interface{}, 0, len(l.values)+len(keysAndValues))
+	l.values = append(append(values, l.values...), keysAndValues...)
+	return l
+}
+
+func (l writerLogger) WithCallDepth(depth int) logr.Logger {
+	l.depth += depth
+	return l
+}
+
+func (l writerLogger) LoggerInfo() (string, []interface{}, int) {
+	values := make([]interface{}, len(l.values))
+	copy(values, l.values)
+	return RenderName(l.names), values, int(l.level)
+}
@@ -0,0 +1,136 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package klog
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewLoggerToWriter(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	defer logging.verbosity.set(logging.verbosity.get())
+	logging.verbosity.set(1)
+
+	var first, second bytes.Buffer
+	a := NewLoggerToWriter(&first).WithName("a")
+	b := NewLoggerToWriter(&second).WithValues("pod", "kubedns")
+
+	a.Info("hello", "count", 1)
+	a.V(1).Info("verbose")
+	a.V(2).Info("too verbose")
+	b.Error(errors.New("failed"), "sync")
+	b.WithName("b").V(1).Info("world")
+
+	firstLines := strings.Split(strings.TrimSuffix(first.String(), "\n"), "\n")
+	if len(firstLines) != 2 {
+		t.Fatalf("expected 2 lines in first writer, got %q", first.String())
+	}
+	if want := `"a: hello" count=1`; !strings.HasSuffix(firstLines[0], want) {
+		t.Errorf("expected first line to end with %q, got %q", want, firstLines[0])
+	}
+	if !strings.HasPrefix(firstLines[0], "I") || !strings.Contains(firstLines[0], " klog_writer_logger_test.go:") {
+		t.Errorf("unexpected header in %q", firstLines[0])
+	}
+	if want := `"a: verbose"`; !strings.HasSuffix(firstLines[1], want) {
+		t.Errorf("expected second line to end with %q, got %q", want, firstLines[1])
+	}
+
+	secondLines := strings.Split(strings.TrimSuffix(second.String(), "\n"), "\n")
+	if len(secondLines) != 2 {
+		t.Fatalf("expected 2 lines in second writer, got %q", second.String())
+	}
+	if want := `"sync" err="failed" pod="kubedns"`; !strings.HasPrefix(secondLines[0], "E") || !strings.HasSuffix(secondLines[0], want) {
+		t.Errorf("expected error line ending with %q, got %q", want, secondLines[0])
+	}
+	if want := `"b: world" pod="kubedns"`; !strings.HasSuffix(secondLines[1], want) {
+		t.Errorf("expected second line to end with %q, got %q", want, secondLines[1])
+	}
+
+	for _, sev := range []severity{infoLog, errorLog} {
+		if contents(sev) != "" {
+			t.Errorf("unexpected global output for %s: %q", severityName[sev], contents(sev))
+		}
+	}
+}
+
+func TestNewLoggerToWriterVModule(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	defer logging.vmodule.Set("")
+	logging.vmodule.Set("klog_writer_logger_test=2")
+
+	var buf bytes.Buffer
+	logger := NewLoggerToWriter(&buf)
+	if !logger.V(2).Enabled() {
+		t.Error("V(2) not enabled by -vmodule")
+	}
+	if logger.V(3).Enabled() {
+		t.Error("V(3) enabled")
+	}
+	logger.V(2).Info("enabled")
+	logger.V(3).Info("disabled")
+	if want := `"enabled"` + "\n"; !strings.HasSuffix(buf.String(), want) || strings.Contains(buf.String(), "disabled") {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+}
+
+func TestNameRenderer(t *testing.T) {
+	setFlags()
+	defer logging.swap(logging.newBuffers())
+	defer SetNameRenderer(nil)
+
+	var buf bytes.Buffer
+	logger := NewLoggerToWriter(&buf).WithName("main")
+	helper := logger.WithName("helper")
+
+	helper.Info("default")
+	SetNameRenderer(func(segments []string) string {
+		return "[" + strings.Join(segments, "][") + "]"
+	})
+	helper.Info("custom")
+	logger.Info("single")
+	NewLoggerToWriter(&buf).Info("unnamed")
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	expected := []string{`"main/helper: default"`, `"[main][helper]: custom"`, `"[main]: single"`, `"unnamed"`}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines, got %q", len(expected), buf.String())
+	}
+	for i, want := range expected {
+		if !strings.HasSuffix(lines[i], want) {
+			t.Errorf("expected line %d to end with %q, got %q", i, want, lines[i])
+		}
+	}
+}
+
+func TestLoggerInfo(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLoggerToWriter(&buf).WithName("a").V(1).WithValues("pod", "kubedns").WithName("b").V(1)
+	name, values, verbosity := LoggerInfo(logger)
+	if name != "a/b" {
+		t.Errorf("expected name %q, got %q", "a/b", name)
+	}
+	if want := []interface{}{"pod", "kubedns"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("expected values %v, got %v", want, values)
+	}
+	if verbosity != 2 {
+		t.Errorf("expected verbosity 2, got %d", verbosity)
+	}
+}
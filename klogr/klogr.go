@@ -38,6 +38,36 @@ func WithFormat(format Format) Option {
 	}
 }
 
+// WithErrorKey changes the key under which the error passed to Error is
+// logged. The default is "error" for FormatSerialize and "err" for
+// FormatKlog.
+func WithErrorKey(key string) Option {
+	return func(l *klogger) {
+		l.errorKey = key
+	}
+}
+
+// WithVerbosityKey adds the verbosity level of the logger to each Info
+// message under the given key, including level 0. V(n) only selects
+// whether a message is written: it is always logged with INFO severity,
+// so the key is the only way to tell the level of a message in the output.
+// The default is to not log the level.
+func WithVerbosityKey(key string) Option {
+	return func(l *klogger) {
+		l.verbosityKey = key
+	}
+}
+
+// WithNameKey adds the names added with WithName to each message under the
+// given key, like the NameKey of zapr, in addition to the prefix of the
+// message. Nothing is added for loggers without a name. The default is to
+// not add the key.
+func WithNameKey(key string) Option {
+	return func(l *klogger) {
+		l.nameKey = key
+	}
+}
+
 // New returns a logr.Logger which serializes output itself
 // and writes it via klog.
 func New() logr.Logger {
@@ -50,7 +80,6 @@ func New() logr.Logger {
 func NewWithOptions(options ...Option) logr.Logger {
 	l := klogger{
 		level:  0,
-		prefix: "",
 		values: nil,
 		format: FormatKlog,
 	}
@@ -61,19 +90,25 @@ func NewWithOptions(options ...Option) logr.Logger {
 }
 
 type klogger struct {
-	level     int
-	callDepth int
-	prefix    string
-	values    []interface{}
-	format    Format
+	level        int
+	callDepth    int
+	names        []string
+	values       []interface{}
+	format       Format
+	errorKey     string
+	verbosityKey string
+	nameKey      string
 }
 
 func (l klogger) clone() klogger {
 	return klogger{
-		level:  l.level,
-		prefix: l.prefix,
-		values: copySlice(l.values),
-		format: l.format,
+		level:        l.level,
+		names:        l.names,
+		values:       copySlice(l.values),
+		format:       l.format,
+		errorKey:     l.errorKey,
+		verbosityKey: l.verbosityKey,
+		nameKey:      l.nameKey,
 	}
 }
 
@@ -178,38 +213,64 @@ func flatten(kvList ...interface{}) string {
 	return buf.String()
 }
 
-func pretty(value interface{}) string {
+func pretty(value interface{}) (result string) {
 	if err, ok := value.(error); ok {
 		if _, ok := value.(json.Marshaler); !ok {
 			value = err.Error()
 		}
 	}
+	// A MarshalJSON method which panics must not take down the process.
+	defer func() {
+		if r := recover(); r != nil {
+			result = internalError(fmt.Sprintf("panic: %v", r))
+		}
+	}()
 	buffer := &bytes.Buffer{}
 	encoder := json.NewEncoder(buffer)
 	encoder.SetEscapeHTML(false)
-	encoder.Encode(value)
+	if err := encoder.Encode(value); err != nil {
+		return internalError(err.Error())
+	}
 	return strings.TrimSpace(string(buffer.Bytes()))
 }
 
+// internalError returns the JSON-encoded placeholder for a value which could
+// not be encoded.
+func internalError(reason string) string {
+	return pretty(fmt.Sprintf("<internal error: %s>", reason))
+}
+
 func (l klogger) Info(msg string, kvList ...interface{}) {
 	if l.Enabled() {
+		if l.verbosityKey != "" {
+			kvList = append([]interface{}{l.verbosityKey, l.level}, kvList...)
+		}
+		kvList = l.withName(kvList)
 		switch l.format {
 		case FormatSerialize:
 			msgStr := flatten("msg", msg)
 			trimmed := trimDuplicates(l.values, kvList)
 			fixedStr := flatten(trimmed[0]...)
 			userStr := flatten(trimmed[1]...)
-			klog.InfoDepth(framesToCaller()+l.callDepth, l.prefix, " ", msgStr, " ", fixedStr, " ", userStr)
+			klog.InfoDepth(framesToCaller()+l.callDepth, klog.RenderName(l.names), " ", msgStr, " ", fixedStr, " ", userStr)
 		case FormatKlog:
 			trimmed := trimDuplicates(l.values, kvList)
-			if l.prefix != "" {
-				msg = l.prefix + ": " + msg
+			if prefix := klog.RenderName(l.names); prefix != "" {
+				msg = prefix + ": " + msg
 			}
 			klog.InfoSDepth(framesToCaller()+l.callDepth, msg, append(trimmed[0], trimmed[1]...)...)
 		}
 	}
 }
 
+// withName adds the name under the key set with WithNameKey to kvList.
+func (l klogger) withName(kvList []interface{}) []interface{} {
+	if l.nameKey == "" || len(l.names) == 0 {
+		return kvList
+	}
+	return append([]interface{}{l.nameKey, klog.RenderName(l.names)}, kvList...)
+}
+
 func (l klogger) Enabled() bool {
 	return bool(klog.V(klog.Level(l.level)).Enabled())
 }
@@ -220,19 +281,30 @@ func (l klogger) Error(err error, msg string, kvList ...interface{}) {
 	if err != nil {
 		loggableErr = err.Error()
 	}
+	kvList = l.withName(kvList)
 	switch l.format {
 	case FormatSerialize:
-		errStr := flatten("error", loggableErr)
+		errorKey := l.errorKey
+		if errorKey == "" {
+			errorKey = "error"
+		}
+		errStr := flatten(errorKey, loggableErr)
 		trimmed := trimDuplicates(l.values, kvList)
 		fixedStr := flatten(trimmed[0]...)
 		userStr := flatten(trimmed[1]...)
-		klog.ErrorDepth(framesToCaller()+l.callDepth, l.prefix, " ", msgStr, " ", errStr, " ", fixedStr, " ", userStr)
+		klog.ErrorDepth(framesToCaller()+l.callDepth, klog.RenderName(l.names), " ", msgStr, " ", errStr, " ", fixedStr, " ", userStr)
 	case FormatKlog:
 		trimmed := trimDuplicates(l.values, kvList)
-		if l.prefix != "" {
-			msg = l.prefix + ": " + msg
+		if prefix := klog.RenderName(l.names); prefix != "" {
+			msg = prefix + ": " + msg
+		}
+		kvs := append(trimmed[0], trimmed[1]...)
+		if l.errorKey != "" && err != nil {
+			// klog always uses "err", so pass the error as a normal value.
+			kvs = append([]interface{}{l.errorKey, err}, kvs...)
+			err = nil
 		}
-		klog.ErrorSDepth(framesToCaller()+l.callDepth, err, msg, append(trimmed[0], trimmed[1]...)...)
+		klog.ErrorSDepth(framesToCaller()+l.callDepth, err, msg, kvs...)
 	}
 }
 
@@ -243,14 +315,12 @@ func (l klogger) V(level int) logr.Logger {
 }
 
 // WithName returns a new logr.Logger with the specified name appended.  klogr
-// uses '/' characters to separate name elements.  Callers should not pass '/'
-// in the provided name string, but this library does not actually enforce that.
+// uses '/' characters to separate name elements unless klog.SetNameRenderer
+// installed something else.  Callers should not pass '/' in the provided name
+// string, but this library does not actually enforce that.
 func (l klogger) WithName(name string) logr.Logger {
 	new := l.clone()
-	if len(l.prefix) > 0 {
-		new.prefix = l.prefix + "/"
-	}
-	new.prefix += name
+	new.names = append(l.names[:len(l.names):len(l.names)], name)
 	return new
 }
 
@@ -260,6 +330,11 @@ func (l klogger) WithValues(kvList ...interface{}) logr.Logger {
 	return new
 }
 
+// LoggerInfo implements klog.LoggerInfoProvider.
+func (l klogger) LoggerInfo() (string, []interface{}, int) {
+	return klog.RenderName(l.names), copySlice(l.values), l.level
+}
+
 func (l klogger) WithCallDepth(depth int) logr.Logger {
 	new := l.clone()
 	new.callDepth += depth
@@ -268,3 +343,4 @@ func (l klogger) WithCallDepth(depth int) logr.Logger {
 
 var _ logr.Logger = klogger{}
 var _ logr.CallDepthLogger = klogger{}
+var _ klog.LoggerInfoProvider = klogger{}
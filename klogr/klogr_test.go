@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"errors"
 	"flag"
+	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -89,6 +91,24 @@ func testOutput(t *testing.T, format string) {
 			expectedOutput: ` "msg"="test"  "akey"="avalue"
 `,
 			expectedKlogOutput: `"test" akey="avalue"
+`,
+		},
+		"should only print the last of three duplicate keys with mixed values": {
+			klogr:         new().WithValues("akey", "avalue", "bkey", "bvalue"),
+			text:          "test",
+			keysAndValues: []interface{}{"akey", "avalue2", "ckey", "cvalue", "akey", "avalue"},
+			expectedOutput: ` "msg"="test" "bkey"="bvalue" "akey"="avalue" "ckey"="cvalue"
+`,
+			expectedKlogOutput: `"test" bkey="bvalue" ckey="cvalue" akey="avalue"
+`,
+		},
+		"should only print the last of three duplicate keys passed to the logger": {
+			klogr:         new().WithValues("akey", "avalue", "akey", "avalue2").WithValues("bkey", "bvalue", "akey", "avalue"),
+			text:          "test",
+			keysAndValues: []interface{}{"ckey", "cvalue"},
+			expectedOutput: ` "msg"="test" "akey"="avalue" "bkey"="bvalue" "ckey"="cvalue"
+`,
+			expectedKlogOutput: `"test" bkey="bvalue" akey="avalue" ckey="cvalue"
 `,
 		},
 		"should sort within logger and parameter key/value pairs in the default format and dump the logger pairs first": {
@@ -150,6 +170,15 @@ func testOutput(t *testing.T, format string) {
 			expectedOutput: ` "msg"="test"  "err"="WHOOPS"
 `,
 			expectedKlogOutput: `"test" err="whoops"
+`,
+		},
+		"should log a placeholder if MarshalJSON panics": {
+			klogr:         new().V(0),
+			text:          "test",
+			keysAndValues: []interface{}{"akey", panicJSON{}},
+			expectedOutput: ` "msg"="test"  "akey"="<internal error: panic: boom>"
+`,
+			expectedKlogOutput: `"test" akey={}
 `,
 		},
 		"should correctly print regular error types when using logr.Error": {
@@ -232,3 +261,157 @@ func (e *customErrorJSON) Error() string {
 func (e *customErrorJSON) MarshalJSON() ([]byte, error) {
 	return json.Marshal(strings.ToUpper(e.s))
 }
+
+type panicJSON struct{}
+
+func (panicJSON) MarshalJSON() ([]byte, error) {
+	panic("boom")
+}
+
+func TestErrorKey(t *testing.T) {
+	klog.SetVerbosity(10)
+	klog.SetSkipHeaders(true)
+	klog.SetLogToStderr(false)
+	klog.SetAlsoLogToStderr(false)
+	klog.SetStderrThreshold("10")
+
+	tests := map[Format]string{
+		FormatSerialize: ` "msg"="test" "failure"="whoops"  "akey"="avalue"
+`,
+		FormatKlog: `"test" failure="whoops" akey="avalue"
+`,
+	}
+	for format, expectedOutput := range tests {
+		t.Run(string(format), func(t *testing.T) {
+			tmpWriteBuffer := bytes.NewBuffer(nil)
+			klog.SetOutputBySeverity("INFO", tmpWriteBuffer)
+
+			logger := NewWithOptions(WithFormat(format), WithErrorKey("failure"))
+			logger.Error(errors.New("whoops"), "test", "akey", "avalue")
+			klog.Flush()
+
+			if actual := tmpWriteBuffer.String(); actual != expectedOutput {
+				t.Errorf("expected %q did not match actual %q", expectedOutput, actual)
+			}
+		})
+	}
+}
+
+func TestVerbosityKey(t *testing.T) {
+	klog.SetVerbosity(10)
+	klog.SetSkipHeaders(true)
+	klog.SetLogToStderr(false)
+	klog.SetAlsoLogToStderr(false)
+	klog.SetStderrThreshold("10")
+
+	for _, format := range []Format{FormatSerialize, FormatKlog} {
+		for level := 0; level <= 5; level++ {
+			t.Run(fmt.Sprintf("%s/v=%d", format, level), func(t *testing.T) {
+				infoBuffer, warningBuffer := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+				klog.SetOutputBySeverity("INFO", infoBuffer)
+				klog.SetOutputBySeverity("WARNING", warningBuffer)
+
+				logger := NewWithOptions(WithFormat(format), WithVerbosityKey("v"))
+				logger.V(level).Info("test", "akey", "avalue")
+				klog.Flush()
+
+				expectedOutput := map[Format]string{
+					FormatSerialize: fmt.Sprintf(` "msg"="test"  "akey"="avalue" "v"=%d
+`, level),
+					FormatKlog: fmt.Sprintf(`"test" v=%d akey="avalue"
+`, level),
+				}[format]
+				if actual := infoBuffer.String(); actual != expectedOutput {
+					t.Errorf("expected %q did not match actual %q", expectedOutput, actual)
+				}
+				if warningBuffer.Len() > 0 {
+					t.Errorf("expected no WARNING output, got %q", warningBuffer.String())
+				}
+			})
+		}
+	}
+}
+
+func TestNameRenderer(t *testing.T) {
+	klog.SetVerbosity(10)
+	klog.SetSkipHeaders(true)
+	klog.SetLogToStderr(false)
+	klog.SetAlsoLogToStderr(false)
+	klog.SetStderrThreshold("10")
+	klog.SetNameRenderer(func(segments []string) string {
+		return "[" + strings.Join(segments, "][") + "]"
+	})
+	defer klog.SetNameRenderer(nil)
+
+	tests := map[Format]string{
+		FormatSerialize: `[main][helper] "msg"="test"  "akey"="avalue"
+`,
+		FormatKlog: `"[main][helper]: test" akey="avalue"
+`,
+	}
+	for format, expectedOutput := range tests {
+		t.Run(string(format), func(t *testing.T) {
+			tmpWriteBuffer := bytes.NewBuffer(nil)
+			klog.SetOutputBySeverity("INFO", tmpWriteBuffer)
+
+			logger := NewWithOptions(WithFormat(format)).WithName("main").WithName("helper")
+			logger.Info("test", "akey", "avalue")
+			klog.Flush()
+
+			if actual := tmpWriteBuffer.String(); actual != expectedOutput {
+				t.Errorf("expected %q did not match actual %q", expectedOutput, actual)
+			}
+		})
+	}
+}
+
+func TestLoggerInfo(t *testing.T) {
+	logger := New().WithName("main").WithValues("pod", "kubedns").WithName("helper").V(2).WithValues("ns", "kube-system")
+	name, values, verbosity := klog.LoggerInfo(logger)
+	if name != "main/helper" {
+		t.Errorf("expected name %q, got %q", "main/helper", name)
+	}
+	if want := []interface{}{"pod", "kubedns", "ns", "kube-system"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("expected values %v, got %v", want, values)
+	}
+	if verbosity != 2 {
+		t.Errorf("expected verbosity 2, got %d", verbosity)
+	}
+
+	name, values, verbosity = klog.LoggerInfo(logr.Discard())
+	if name != "" || values != nil || verbosity != 0 {
+		t.Errorf("expected no information for an unsupported logger, got %q, %v, %d", name, values, verbosity)
+	}
+}
+
+func TestNameKey(t *testing.T) {
+	klog.SetVerbosity(10)
+	klog.SetSkipHeaders(true)
+	klog.SetLogToStderr(false)
+	klog.SetAlsoLogToStderr(false)
+	klog.SetStderrThreshold("10")
+
+	tests := map[Format]string{
+		FormatSerialize: `main/helper "msg"="test"  "akey"="avalue" "logger"="main/helper"
+ "msg"="unnamed"  
+`,
+		FormatKlog: `"main/helper: test" logger="main/helper" akey="avalue"
+"unnamed"
+`,
+	}
+	for format, expectedOutput := range tests {
+		t.Run(string(format), func(t *testing.T) {
+			tmpWriteBuffer := bytes.NewBuffer(nil)
+			klog.SetOutputBySeverity("INFO", tmpWriteBuffer)
+
+			logger := NewWithOptions(WithFormat(format), WithNameKey("logger"))
+			logger.WithName("main").WithName("helper").Info("test", "akey", "avalue")
+			logger.Info("unnamed")
+			klog.Flush()
+
+			if actual := tmpWriteBuffer.String(); actual != expectedOutput {
+				t.Errorf("expected %q did not match actual %q", expectedOutput, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+// Package syslog implements github.com/go-logr/logr.Logger on top of the
+// system log service. It can be installed as klog backend with
+// klog.SetLogger.
+package syslog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+
+	"github.com/go-logr/logr"
+	"k8s.io/klog/v2"
+)
+
+// NewSyslogLogger returns a logger which sends entries with the given tag
+// to the syslog daemon at addr, using network "tcp", "udp" or "unix", or to
+// the local daemon if network is empty. Entries are written as quoted
+// message followed by key/value pairs, like the structured klog functions.
+//
+// Info maps to LOG_INFO and Error to LOG_ERR. When used as klog backend,
+// warnings are logged with LOG_WARNING and fatal entries with LOG_CRIT.
+//
+// If the connection to the daemon cannot be established, NewSyslogLogger
+// returns the error together with a logger that writes to stderr instead.
+// Entries which cannot be sent later on are also written to stderr.
+func NewSyslogLogger(tag string, network, addr string) (logr.Logger, error) {
+	writer, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_USER, tag)
+	if err != nil {
+		return logger{out: stderrWriter{}}, err
+	}
+	return logger{out: writer}, nil
+}
+
+// priorityWriter is the subset of *syslog.Writer used by logger.
+type priorityWriter interface {
+	Info(msg string) error
+	Warning(msg string) error
+	Err(msg string) error
+	Crit(msg string) error
+}
+
+// stderrWriter is the fallback when there is no connection to syslog.
+type stderrWriter struct{}
+
+func (stderrWriter) write(severity, msg string) error {
+	_, err := fmt.Fprintf(os.Stderr, "%s %s\n", severity, msg)
+	return err
+}
+
+func (w stderrWriter) Info(msg string) error    { return w.write("INFO", msg) }
+func (w stderrWriter) Warning(msg string) error { return w.write("WARNING", msg) }
+func (w stderrWriter) Err(msg string) error     { return w.write("ERROR", msg) }
+func (w stderrWriter) Crit(msg string) error    { return w.write("FATAL", msg) }
+
+type logger struct {
+	out    priorityWriter
+	level  int
+	prefix string
+	values []interface{}
+}
+
+var _ klog.SeverityLogger = logger{}
+
+// Enabled compares the verbosity of the logger with -v. -vmodule is not
+// checked because it would be matched against this package instead of the
+// caller.
+func (l logger) Enabled() bool {
+	return klog.Level(l.level) <= klog.Verbosity()
+}
+
+func (l logger) Info(msg string, keysAndValues ...interface{}) {
+	if !l.Enabled() {
+		return
+	}
+	l.InfoWithSeverity("INFO", msg, keysAndValues...)
+}
+
+// InfoWithSeverity implements klog.SeverityLogger. It is only called by
+// klog, which has already checked -v and -vmodule for the file of the log
+// call, so the verbosity is not checked again.
+func (l logger) InfoWithSeverity(severity string, msg string, keysAndValues ...interface{}) {
+	text := l.format(nil, msg, keysAndValues)
+	var err error
+	switch severity {
+	case "WARNING":
+		err = l.out.Warning(text)
+	case "FATAL":
+		err = l.out.Crit(text)
+	default:
+		err = l.out.Info(text)
+	}
+	if err != nil {
+		stderrWriter{}.write(severity, text) // ignore err
+	}
+}
+
+func (l logger) Error(err error, msg string, keysAndValues ...interface{}) {
+	text := l.format(err, msg, keysAndValues)
+	if err := l.out.Err(text); err != nil {
+		stderrWriter{}.write("ERROR", text) // ignore err
+	}
+}
+
+func (l logger) format(err error, msg string, keysAndValues []interface{}) string {
+	if l.prefix != "" {
+		msg = l.prefix + ": " + msg
+	}
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "%q", msg)
+	if err != nil {
+		fmt.Fprintf(b, " err=%q", err.Error())
+	}
+	writeKVs(b, l.values)
+	writeKVs(b, keysAndValues)
+	return b.String()
+}
+
+func writeKVs(w io.Writer, keysAndValues []interface{}) {
+	for i := 0; i < len(keysAndValues); i += 2 {
+		var v interface{} = "(MISSING)"
+		if i+1 < len(keysAndValues) {
+			v = keysAndValues[i+1]
+		}
+		switch v.(type) {
+		case string, error, fmt.Stringer:
+			fmt.Fprintf(w, " %s=%q", keysAndValues[i], v)
+		case []byte:
+			fmt.Fprintf(w, " %s=%+q", keysAndValues[i], v)
+		default:
+			fmt.Fprintf(w, " %s=%+v", keysAndValues[i], v)
+		}
+	}
+}
+
+func (l logger) V(level int) logr.Logger {
+	l.level += level
+	return l
+}
+
+func (l logger) WithName(name string) logr.Logger {
+	if l.prefix != "" {
+		l.prefix += "/"
+	}
+	l.prefix += name
+	return l
+}
+
+func (l logger) WithValues(keysAndValues ...interface{}) logr.Logger {
+	values := make([]interface{}, 0, len(l.values)+len(keysAndValues))
+	l.values = append(append(values, l.values...), keysAndValues...)
+	return l
+}
@@ -0,0 +1,125 @@
+// Copyright 2021 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package syslog
+
+import (
+	"errors"
+	"flag"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+func TestSyslogLogger(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+
+	logger, err := NewSyslogLogger("test", "udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logger = logger.WithName("controller").WithValues("pod", "kubedns")
+	logger.Info("updated", "count", 1)
+	logger.Error(errors.New("failed"), "sync")
+	logger.(klog.SeverityLogger).InfoWithSeverity("WARNING", "slow")
+	logger.V(10).Info("disabled")
+
+	// The priority is facility LOG_USER (8) plus the severity.
+	expected := [][]string{
+		{`<14>`, ` test[`, `"controller: updated" pod="kubedns" count=1`},
+		{`<11>`, ` test[`, `"controller: sync" err="failed" pod="kubedns"`},
+		{`<12>`, ` test[`, `"controller: slow" pod="kubedns"`},
+	}
+	buffer := make([]byte, 4096)
+	for _, parts := range expected {
+		conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		n, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		message := string(buffer[:n])
+		if !strings.HasPrefix(message, parts[0]) {
+			t.Errorf("expected priority %s in %q", parts[0], message)
+		}
+		for _, part := range parts[1:] {
+			if !strings.Contains(message, part) {
+				t.Errorf("expected %q in %q", part, message)
+			}
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, _, err := conn.ReadFrom(buffer); err == nil {
+		t.Errorf("unexpected message %q", buffer[:n])
+	}
+}
+
+func TestSyslogLoggerVmodule(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+
+	logger, err := NewSyslogLogger("test", "udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var fs flag.FlagSet
+	klog.InitFlags(&fs)
+	if err := fs.Set("vmodule", "syslog_test=2"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer fs.Set("vmodule", "")
+	klog.SetLogger(logger)
+	defer klog.SetLogger(nil)
+
+	// The -vmodule pattern applies to this file, not to syslog.go.
+	klog.V(2).InfoS("enabled")
+	klog.V(3).InfoS("disabled")
+
+	buffer := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	n, _, err := conn.ReadFrom(buffer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if message := string(buffer[:n]); !strings.Contains(message, `"enabled"`) {
+		t.Errorf("expected V(2) entry, got %q", message)
+	}
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, _, err := conn.ReadFrom(buffer); err == nil {
+		t.Errorf("unexpected message %q", buffer[:n])
+	}
+}
+
+func TestSyslogLoggerFallback(t *testing.T) {
+	logger, err := NewSyslogLogger("test", "unix", "/no/such/socket")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if logger == nil {
+		t.Fatal("expected a fallback logger")
+	}
+	logger.Info("written to stderr")
+}